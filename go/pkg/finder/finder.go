@@ -0,0 +1,389 @@
+// Package finder is the interactive fuzzy finder of the 'my-fuzzy-finder' program, as a library. Use 'Run' to run a
+// finder as a whole program, or use 'New' to embed the finder's 'Model' into another Bubble Tea program.
+//
+// For example, to let the user choose a directory:
+//
+//	result, err := finder.Run(ctx, finder.Options{Items: dirs})
+//	if err != nil || len(result.Selection) == 0 {
+//		// Aborted (see 'ErrAborted'), failed, or quit without a match
+//		return
+//	}
+//	dir := result.Selection[0].Text
+//
+// The matching is done by the 'my-fuzzy-finder-lib' package, which is a pared down version of fzf's matching.
+package finder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/charmbracelet/bubbles/cursor"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"io"
+	"log"
+	fz "my-software/pkg/my-fuzzy-finder-lib"
+	"my-software/pkg/nuon"
+	"net"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ErrAborted is returned by 'Run' and 'Model.Result' when the user aborted (e.g. with "esc") instead of accepting a
+// selection.
+var ErrAborted = errors.New("the finder was aborted")
+
+// Options configure a finder. Only the items are required.
+type Options struct {
+	// The items to choose from. These can be left out if 'Values' or 'Table' is given.
+	Items []string
+
+	// Structured values behind the items, one per item. When 'Items' is left out, each item is the value's text, or
+	// the text of its 'DisplayField' field for records. Query terms can be qualified with a field name to match other
+	// fields of records (e.g. 'name:readme !type:dir size:>1mb').
+	Values       []nuon.Value
+	DisplayField string
+
+	// For record items, the field to group the items by. Each group of matches is shown under a header with the
+	// field's text. The groups are in the order of their best match.
+	GroupBy string
+
+	// Tabular items. The rows are displayed as aligned columns under a header row. When 'Items' is left out, each
+	// item is the row's cells in the match columns, joined by tabs.
+	Table *Table
+
+	// Whether items have ANSI color codes. The colors are displayed, but they aren't matched against. With
+	// 'KeepANSI', the selection's 'Raw' text has the original codes.
+	ANSI     bool
+	KeepANSI bool
+
+	// Whether identical items are collapsed into one item, and whether to show their count or rank the most frequent
+	// items first.
+	Dedupe          bool
+	ShowCount       bool
+	RankByFrequency bool
+
+	// Frecency scores by item text. Items with a higher score rank higher (see the 'my-fuzzy-finder' frecency store).
+	Frecency map[string]float64
+
+	// The criteria for ordering matches with the same score: "length", "begin", "end" and "index". By default,
+	// "length".
+	Tiebreaks []string
+
+	// Sorting and display options. See the 'my-fuzzy-finder' flags of the same names.
+	NoSort bool
+	Tac    bool
+	Wrap   bool
+
+	// The initial matching modes. These can be toggled at runtime.
+	Exact bool
+	Typos bool
+
+	// Whether the '^' and '$' anchors apply per line, and multi-line items are collapsed to their matching lines plus
+	// 'Context' lines of context.
+	MatchingLines bool
+	Context       int
+
+	// The number of lines to keep visible above and below the cursor when scrolling.
+	ScrollOff int
+
+	// Keys that accept the selection in addition to "enter", spelled the fzf way (e.g. "ctrl-o"). The key that was
+	// used is reported in the result.
+	Expect []string
+
+	// Key bindings, spelled the fzf way (e.g. "ctrl-r"). These are added to the default bindings (see
+	// 'DefaultBindings'). Use 'ParseAction' to parse actions like "reload(ls)".
+	Bindings map[string]Action
+
+	// The styles. By default, 'DefaultStyles()'.
+	Styles *Styles
+
+	// An index of the items that narrows down which items a query can match, for very large and mostly static
+	// corpora (see 'fz.Index'). It must be an index of 'Items', in the same order. Items that stream in later are
+	// always matched. The index is no longer used once the items are reordered, like when duplicates are collapsed or
+	// the oldest items are dropped. A query that the index can't narrow down, like one with only inverted terms, needs
+	// all items to be matched, so from then on the indexed items are also kept in a store (see 'fz.Store').
+	Index *fz.Index
+
+	// More items that stream in while the finder runs (e.g. from a file walker). Only used by 'Run'.
+	Stream <-chan []string
+
+	// The maximum number of items to keep. When more items stream in, the oldest items are dropped. They are dropped in
+	// chunks of a quarter of the maximum, so there can be up to that many more items at a time. This keeps the memory
+	// capped on endless streams, like a followed log file. By default, there is no maximum.
+	MaxItems int
+
+	// A listener for remote-control connections. Only used by 'Run'. See 'remoteRequest' for the protocol.
+	Listener net.Listener
+
+	// Where the finder is rendered. By default, the terminal ('/dev/tty'), so that the finder works in the middle of a
+	// pipeline. Only used by 'Run'.
+	Output io.Writer
+
+	// Debug logs. By default, nothing is logged.
+	Log *log.Logger
+}
+
+// Table is tabular data, like CSV with a header row.
+type Table struct {
+	Header []string
+	Rows   [][]string
+
+	// The indices of the columns that are matched against. By default, all columns.
+	MatchColumns []int
+}
+
+// Action is what a key binding does. The actions are "accept", "abort", "toggle-sort", "toggle-typos",
+// "toggle-exact", "jump", "jump-accept" and "reload" (with a shell command as the argument).
+type Action struct {
+	Name string
+	Arg  string
+}
+
+// ParseAction parses an action spelled the fzf way, like "accept" or "reload(git branch --all)".
+func ParseAction(spec string) (Action, error) {
+	switch {
+	case slices.Contains([]string{"accept", "abort", "toggle-sort", "toggle-typos", "toggle-exact", "jump", "jump-accept"}, spec):
+		return Action{Name: spec}, nil
+	case strings.HasPrefix(spec, "reload(") && strings.HasSuffix(spec, ")"):
+		return Action{Name: "reload", Arg: spec[len("reload(") : len(spec)-1]}, nil
+	default:
+		return Action{}, fmt.Errorf("unsupported action '%s'", spec)
+	}
+}
+
+// DefaultBindings returns the key bindings that every finder has, unless they are overridden.
+func DefaultBindings() map[string]Action {
+	return map[string]Action{
+		"ctrl-s": {Name: "toggle-sort"},
+		"ctrl-t": {Name: "toggle-typos"},
+		"alt-e":  {Name: "toggle-exact"},
+		"ctrl-j": {Name: "jump"},
+	}
+}
+
+// Styles are the lipgloss styles of the parts of a finder.
+type Styles struct {
+	// The margin around the finder. It's dropped when the terminal is small.
+	Frame           lipgloss.Style
+	Item            lipgloss.Style
+	ItemBox         lipgloss.Style
+	SelectedItem    lipgloss.Style
+	SelectedItemBox lipgloss.Style
+	Prompt          lipgloss.Style
+	Cursor          lipgloss.Style
+	NoItems         lipgloss.Style
+	Count           lipgloss.Style
+	JumpLabel       lipgloss.Style
+	TableHeader     lipgloss.Style
+	GroupHeader     lipgloss.Style
+}
+
+// DefaultStyles returns the styles of the 'my-fuzzy-finder' program.
+func DefaultStyles() Styles {
+	return Styles{
+		Frame:        lipgloss.NewStyle().Margin(1, 2),
+		Item:         lipgloss.NewStyle().Foreground(lipgloss.Color("0")),
+		ItemBox:      lipgloss.NewStyle().Padding(0, 0, 0, 2),
+		SelectedItem: lipgloss.NewStyle().Foreground(lipgloss.Color("#DA5CE4")),
+		SelectedItemBox: lipgloss.NewStyle().
+			Border(lipgloss.NormalBorder(), false, false, false, true).
+			BorderForeground(lipgloss.Color("#DA5CE4")).
+			Padding(0, 0, 0, 1),
+		Prompt: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("100")),
+		Cursor: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#DA5CE4")),
+		NoItems: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("245")),
+		Count: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("245")),
+		JumpLabel: lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("#DA5CE4")),
+		TableHeader: lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("245")).
+			Padding(0, 0, 0, 2),
+		GroupHeader: lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("100")).
+			Padding(0, 0, 0, 2),
+	}
+}
+
+// Result is the outcome of a finder that the user accepted.
+type Result struct {
+	// The key that accepted the selection, as it was spelled in 'Expect'. This is empty when the selection was accepted
+	// with "enter".
+	Key string
+
+	// The final query.
+	Query string
+
+	// The selected items. This is empty when nothing matched the query.
+	Selection []Selection
+}
+
+// Selection is a selected item.
+type Selection struct {
+	// The index of the item in the input. For de-duplicated items, this is the index of the first occurrence, and
+	// 'Indices' has the indices of all occurrences.
+	Index   int
+	Indices []int
+
+	// The item text, and the original text with ANSI color codes (see 'KeepANSI'). Without ANSI codes, these are the
+	// same.
+	Text string
+	Raw  string
+
+	// The structured value or the table row behind the item, if there is one.
+	Value *nuon.Value
+	Row   []string
+}
+
+// DoneMsg is sent when the user accepted or aborted the finder. Use 'Model.Result' for the outcome. The finder doesn't
+// quit the program on its own, so that it can be embedded.
+type DoneMsg struct{}
+
+// The labels for jump mode, in the order they are assigned to the visible items. The home row comes first.
+const jumpLabels = "asdfghjklqwertyuiopzxcvbnm1234567890"
+
+// Model is the finder's Bubble Tea model. Create it with 'New'.
+type Model struct {
+	s                      *state
+	input                  textinput.Model
+	cursor                 cursor.Model
+	height                 int
+	width                  int
+	columnWidths           []int
+	item                   int
+	matches                []matchedItem
+	list                   []matchedItem
+	heights                []int
+	headers                []bool
+	rank                   int
+	offset                 int
+	finished               bool
+	completedWithSelection bool
+	acceptKey              string
+	frame                  lipgloss.Style
+	reloadGeneration       int
+	reloadValue            *string
+	sort                   bool
+	typos                  bool
+	exact                  bool
+	jumping                bool
+	jumpAccept             bool
+}
+
+// New creates a finder model. The model starts with no size, so send it a 'tea.WindowSizeMsg' (Bubble Tea does this
+// for the top-level model).
+func New(opts Options) Model {
+	s := newState(opts)
+	input := textinput.New()
+	input.PromptStyle = s.styles.Prompt
+	input.CharLimit = 64
+	input.Cursor.Style = s.styles.Cursor
+	input.Focus()
+
+	return updatePrompt(Model{
+		s:     s,
+		input: input,
+		sort:  !opts.NoSort,
+		typos: opts.Typos,
+		exact: opts.Exact,
+	})
+}
+
+// Len is the number of items. This is less than the number of input items when identical items are collapsed.
+func (m Model) Len() int {
+	return len(m.s.items)
+}
+
+// Result returns the outcome of the finder, once it's done (see 'DoneMsg'). The error is 'ErrAborted' if the user
+// aborted.
+func (m Model) Result() (Result, error) {
+	if !m.completedWithSelection {
+		return Result{}, ErrAborted
+	}
+	result := Result{Key: m.acceptKey, Query: m.input.Value()}
+	if m.item >= 0 {
+		result.Selection = append(result.Selection, m.s.selection(m.item))
+	}
+	return result, nil
+}
+
+// Run runs a finder as a whole Bubble Tea program, until the user accepts or aborts it, or the context is canceled.
+func Run(ctx context.Context, opts Options) (Result, error) {
+	output := opts.Output
+	if output == nil {
+		// Render to the TTY. Otherwise, when the program is part of a pipeline, the TUI isn't rendered. This problem,
+		// explanation, and work around is well described here: https://github.com/charmbracelet/bubbletea/issues/860#issue-1983089654
+		tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+		if err != nil {
+			return Result{}, fmt.Errorf("opening /dev/tty: %w", err)
+		}
+		defer tty.Close()
+		output = tty
+	}
+
+	m := New(opts)
+	p := tea.NewProgram(program{Model: m}, tea.WithAltScreen(), tea.WithOutput(output), tea.WithContext(ctx))
+	return run(ctx, p, opts, m.s)
+}
+
+// Run the program of a finder, with the remote control and the stream of items.
+func run(ctx context.Context, p *tea.Program, opts Options, s *state) (Result, error) {
+	if opts.Listener != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go serve(opts.Listener, p, done, s.log)
+	}
+	if opts.Stream != nil {
+		go func() {
+			for items := range opts.Stream {
+				p.Send(itemsMsg{items: items})
+			}
+		}()
+	}
+
+	final, err := p.Run()
+	if err != nil {
+		if errors.Is(err, tea.ErrProgramKilled) && ctx.Err() != nil {
+			return Result{}, ctx.Err()
+		}
+		return Result{}, err
+	}
+	return final.(program).Result()
+}
+
+// program runs a finder as a whole program. It quits when the finder is done. When replaying a script, it reports the
+// frames (see 'Replay').
+type program struct {
+	Model
+	frame func(view string)
+}
+
+func (p program) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case DoneMsg:
+		return p, tea.Quit
+	case frameMsg:
+		if p.frame != nil {
+			p.frame(p.View())
+		}
+		close(msg.done)
+		return p, nil
+	}
+	m, cmd := p.Model.Update(msg)
+	p.Model = m.(Model)
+	return p, cmd
+}
+
+// The command that tells the program that the finder is done.
+func done() tea.Msg {
+	return DoneMsg{}
+}
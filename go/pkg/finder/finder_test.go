@@ -0,0 +1,347 @@
+package finder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	fz "my-software/pkg/my-fuzzy-finder-lib"
+	"my-software/pkg/nuon"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Update the golden files of the replay tests")
+
+// Drive a model with messages, like a Bubble Tea program would, and return the final model.
+func drive(m Model, msgs ...tea.Msg) Model {
+	for _, msg := range msgs {
+		updated, _ := m.Update(msg)
+		m = updated.(Model)
+	}
+	return m
+}
+
+// Build an index of the items.
+func index(items ...string) *fz.Index {
+	var b fz.IndexBuilder
+	for _, item := range items {
+		b.Add(item)
+	}
+	var buf bytes.Buffer
+	b.WriteTo(&buf)
+	x, err := fz.ReadIndex(&buf)
+	if err != nil {
+		panic(err)
+	}
+	return x
+}
+
+func TestModel(t *testing.T) {
+	indexed := []string{"main.go", "README.md", "go.mod", "docs/README.md"}
+	tests := map[string]struct {
+		opts              Options
+		msgs              []tea.Msg
+		expectedKey       string
+		expectedSelection []string
+		// The input indices of the selection, if they are checked
+		expectedIndices []int
+		expectedErr     error
+	}{
+		"Accept the best match": {
+			opts: Options{Items: []string{"readme-old.txt", "docs", "README.md"}},
+			msgs: []tea.Msg{
+				tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(".md")},
+				tea.KeyMsg{Type: tea.KeyEnter},
+			},
+			expectedSelection: []string{"README.md"},
+		},
+		"Move down": {
+			opts: Options{Items: []string{"a", "b", "c"}},
+			msgs: []tea.Msg{
+				tea.KeyMsg{Type: tea.KeyDown},
+				tea.KeyMsg{Type: tea.KeyEnter},
+			},
+			expectedSelection: []string{"b"},
+		},
+		"Keep the newest items": {
+			opts: Options{Items: []string{"a", "b", "c", "d"}, MaxItems: 2},
+			msgs: []tea.Msg{
+				tea.KeyMsg{Type: tea.KeyDown},
+				tea.KeyMsg{Type: tea.KeyDown},
+				tea.KeyMsg{Type: tea.KeyEnter},
+			},
+			expectedSelection: []string{"d"},
+		},
+		"Match with an index that can't narrow the query down": {
+			opts: Options{Items: indexed, Index: index(indexed...)},
+			msgs: []tea.Msg{
+				tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("!go")},
+				tea.KeyMsg{Type: tea.KeyDown},
+				tea.KeyMsg{Type: tea.KeyEnter},
+			},
+			expectedSelection: []string{"docs/README.md"},
+		},
+		"Drop the oldest items in chunks": {
+			opts: Options{Items: []string{"a", "b", "c", "d"}, MaxItems: 4},
+			msgs: []tea.Msg{
+				itemsMsg{items: []string{"e"}},
+				itemsMsg{items: []string{"f"}},
+				itemsMsg{items: []string{"g"}},
+				tea.KeyMsg{Type: tea.KeyEnter},
+			},
+			expectedSelection: []string{"c"},
+		},
+		"Reload": {
+			opts: Options{Items: []string{"a", "b"}},
+			msgs: []tea.Msg{
+				reloadMsg{first: true, items: []string{"x", "y"}},
+				tea.KeyMsg{Type: tea.KeyEnter},
+			},
+			expectedSelection: []string{"x"},
+		},
+		"Reload after dropping the oldest items": {
+			opts: Options{Items: []string{"a", "b", "c", "d"}, MaxItems: 2},
+			msgs: []tea.Msg{
+				reloadMsg{first: true, items: []string{"x", "y"}},
+				tea.KeyMsg{Type: tea.KeyDown},
+				tea.KeyMsg{Type: tea.KeyEnter},
+			},
+			expectedSelection: []string{"y"},
+			expectedIndices:   []int{1},
+		},
+		"Discard a reload that a remote replace superseded": {
+			opts: Options{Items: []string{"a", "b"}},
+			msgs: []tea.Msg{
+				remoteMsg{request: remoteRequest{Op: "replace", Items: []string{"p", "q"}}, reply: make(chan remoteResponse, 1)},
+				reloadMsg{first: true, items: []string{"x", "y"}},
+				tea.KeyMsg{Type: tea.KeyEnter},
+			},
+			expectedSelection: []string{"p"},
+		},
+		"Keep the items when a reload fails": {
+			opts: Options{Items: []string{"a", "b"}},
+			msgs: []tea.Msg{
+				reloadMsg{first: true, err: errors.New("exec: not started")},
+				tea.KeyMsg{Type: tea.KeyEnter},
+			},
+			expectedSelection: []string{"a"},
+		},
+		"Match with an index": {
+			opts: Options{Items: indexed, Index: index(indexed...)},
+			msgs: []tea.Msg{
+				tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("'.md")},
+				tea.KeyMsg{Type: tea.KeyDown},
+				tea.KeyMsg{Type: tea.KeyEnter},
+			},
+			expectedSelection: []string{"docs/README.md"},
+		},
+		"Accept with an expected key": {
+			opts: Options{Items: []string{"a", "b"}, Expect: []string{"ctrl-o"}},
+			msgs: []tea.Msg{
+				tea.KeyMsg{Type: tea.KeyCtrlO},
+			},
+			expectedKey:       "ctrl-o",
+			expectedSelection: []string{"a"},
+		},
+		"Accept with an expected key spelled the fzf way": {
+			opts: Options{Items: []string{"a", "b"}, Expect: []string{"pgdn", "alt-pgdn"}},
+			msgs: []tea.Msg{
+				tea.KeyMsg{Type: tea.KeyPgDown, Alt: true},
+			},
+			expectedKey:       "alt-pgdn",
+			expectedSelection: []string{"a"},
+		},
+		"No matches": {
+			opts: Options{Items: []string{"a", "b"}},
+			msgs: []tea.Msg{
+				tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("xyz")},
+				tea.KeyMsg{Type: tea.KeyEnter},
+			},
+		},
+		"Abort": {
+			opts: Options{Items: []string{"a", "b"}},
+			msgs: []tea.Msg{
+				tea.KeyMsg{Type: tea.KeyEsc},
+			},
+			expectedErr: ErrAborted,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := drive(New(tt.opts), append([]tea.Msg{tea.WindowSizeMsg{Width: 80, Height: 24}}, tt.msgs...)...)
+
+			result, err := m.Result()
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error %v but got %v", tt.expectedErr, err)
+			}
+			if result.Key != tt.expectedKey {
+				t.Errorf("Expected key '%s' but got '%s'", tt.expectedKey, result.Key)
+			}
+			var selection []string
+			for _, s := range result.Selection {
+				selection = append(selection, s.Text)
+			}
+			if !reflect.DeepEqual(selection, tt.expectedSelection) {
+				t.Errorf("Expected selection %v but got %v", tt.expectedSelection, selection)
+			}
+			if tt.expectedIndices != nil {
+				var indices []int
+				for _, s := range result.Selection {
+					indices = append(indices, s.Index)
+				}
+				if !reflect.DeepEqual(indices, tt.expectedIndices) {
+					t.Errorf("Expected indices %v but got %v", tt.expectedIndices, indices)
+				}
+			}
+		})
+	}
+}
+
+// Replay the scripts in 'testdata' and compare the frames and the result to the golden files. Use '-update' to update
+// the golden files after a deliberate change to the rendering.
+func TestReplay(t *testing.T) {
+	var numbered []string
+	for i := range 30 {
+		numbered = append(numbered, fmt.Sprintf("item %d", i))
+	}
+
+	var grouped []nuon.Value
+	for _, food := range [][2]string{
+		{"apple", "fruit"}, {"carrot", "vegetable"}, {"banana", "fruit"}, {"rice", "grain"}, {"onion", "vegetable"},
+		{"oat", "grain"}, {"mango", "fruit"},
+	} {
+		grouped = append(grouped, nuon.Value{Kind: nuon.Record, Record: []nuon.Field{
+			{Key: "name", Value: nuon.NewString(food[0])},
+			{Key: "kind", Value: nuon.NewString(food[1])},
+		}})
+	}
+
+	tests := map[string]Options{
+		"scroll": {Items: numbered, ScrollOff: 2},
+		"wrap": {Items: []string{
+			"The quick brown fox jumps over the lazy dog",
+			"Pack my box with five dozen liquor jugs",
+			"How vexingly quick daft zebras jump",
+		}, Wrap: true},
+		"group":   {Values: grouped, DisplayField: "name", GroupBy: "kind"},
+		"restore": {Items: []string{"apple", "banana", "cherry", "date", "elderberry", "fig", "grape"}},
+		"count": {Items: []string{
+			"one two three four five six",
+			"one two three four five six",
+			"seven eight nine ten eleven",
+			"seven eight nine ten eleven",
+			"twelve thirteen fourteen fif",
+			"twelve thirteen fourteen fif",
+			"sixteen seventeen eighteen ab",
+			"sixteen seventeen eighteen ab",
+			"nineteen twenty twenty-one ab",
+			"nineteen twenty twenty-one ab",
+		}, Wrap: true, Dedupe: true, ShowCount: true},
+		"multiline": {Items: []string{
+			"alpha\nfirst line\nsecond line",
+			"beta\nanother line",
+			"gamma",
+		}},
+	}
+
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", name+".replay"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			script, err := ParseScript(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var b strings.Builder
+			frames := 0
+			result, err := Replay(context.Background(), opts, script, func(view string) {
+				frames++
+				fmt.Fprintf(&b, "--- frame %d ---\n%s\n", frames, view)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			selection := "(none)"
+			if len(result.Selection) > 0 {
+				selection = result.Selection[0].Text
+			}
+			fmt.Fprintf(&b, "--- result ---\n%s\n", selection)
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(b.String()), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != string(expected) {
+				t.Errorf("The replay doesn't match %s. Got:\n%s", golden, b.String())
+			}
+		})
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	tests := map[string]struct {
+		input         string
+		expectedText  string
+		expectedSpans []ansiSpan
+	}{
+		"Codes accumulate": {
+			input:         "\x1b[1ma\x1b[31mb",
+			expectedText:  "ab",
+			expectedSpans: []ansiSpan{{0, "\x1b[1m"}, {1, "\x1b[1m\x1b[31m"}},
+		},
+		"Reset": {
+			input:         "\x1b[1ma\x1b[0mb",
+			expectedText:  "ab",
+			expectedSpans: []ansiSpan{{0, "\x1b[1m"}, {1, ""}},
+		},
+		"Reset with a leading zero": {
+			input:         "\x1b[1ma\x1b[00mb",
+			expectedText:  "ab",
+			expectedSpans: []ansiSpan{{0, "\x1b[1m"}, {1, ""}},
+		},
+		"Reset and then bold": {
+			input:         "\x1b[31ma\x1b[0;1mb",
+			expectedText:  "ab",
+			expectedSpans: []ansiSpan{{0, "\x1b[31m"}, {1, "\x1b[0;1m"}},
+		},
+		"Palette color 0 isn't a reset": {
+			input:         "\x1b[1ma\x1b[38;5;0mb",
+			expectedText:  "ab",
+			expectedSpans: []ansiSpan{{0, "\x1b[1m"}, {1, "\x1b[1m\x1b[38;5;0m"}},
+		},
+		"Black true color isn't a reset": {
+			input:         "\x1b[1ma\x1b[48;2;0;0;0mb",
+			expectedText:  "ab",
+			expectedSpans: []ansiSpan{{0, "\x1b[1m"}, {1, "\x1b[1m\x1b[48;2;0;0;0m"}},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			text, spans := stripANSI(tt.input)
+			if text != tt.expectedText {
+				t.Errorf("Expected text %q but got %q", tt.expectedText, text)
+			}
+			if !reflect.DeepEqual(spans, tt.expectedSpans) {
+				t.Errorf("Expected spans %q but got %q", tt.expectedSpans, spans)
+			}
+		})
+	}
+}
@@ -0,0 +1,543 @@
+package finder
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/rivo/uniseg"
+	"io"
+	"log"
+	"math"
+	fz "my-software/pkg/my-fuzzy-finder-lib"
+	"my-software/pkg/nuon"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// state is the item data of a finder and its configuration. It's shared by all copies of a 'Model'.
+type state struct {
+	opts Options
+
+	// The master list of items
+	items []string
+
+	// The structured values behind the items, for structured or tabular items. This is nil otherwise.
+	values []nuon.Value
+
+	// The header, rows and match columns of tabular items. These are nil for other kinds of items.
+	tableHeader  []string
+	tableRows    [][]string
+	matchColumns []int
+
+	// The ANSI escape codes are stripped from the items so that they don't get matched against. The colors are kept in
+	// 'ansiSpans' for rendering. The original items are kept in 'raw' only if they are to be output (see 'KeepANSI').
+	ansi      bool
+	ansiSpans [][]ansiSpan
+	raw       []string
+
+	// When identical items are collapsed, 'indices' has the original input indices of each item, 'lookup' finds an
+	// item by its text, and 'inputCount' is the number of input items seen so far.
+	indices    [][]int
+	lookup     map[string]int
+	inputCount int
+
+	// The number of the oldest items that were dropped to stay within 'MaxItems'.
+	dropped int
+
+	// The items for matching, which refers to the master list. The store catches up with the master list when items are
+	// matched (see 'matchAll'), so it may be behind.
+	store fz.Store
+
+	// The index of the items, if there is one (see 'Options.Index'). The index replaces the store for the items that
+	// it covers, so that they aren't all kept in both forms.
+	index *fz.Index
+
+	// The items of the index, once a pattern couldn't be narrowed down by the index. Then all of them have to be
+	// matched, so they are stored after all, and they stay stored for the next such pattern.
+	indexStore fz.Store
+
+	tiebreaks []string
+	styles    Styles
+
+	// Keys that accept the current selection, in addition to "enter". The map is keyed by the Bubble Tea key name
+	// (e.g. "ctrl+o") and the value is the key name as it was spelled in the options (e.g. "ctrl-o"), which is what
+	// we report back in the result.
+	expectKeys map[string]string
+
+	// Key bindings, keyed by the Bubble Tea key name.
+	bindings map[string]Action
+
+	log *log.Logger
+}
+
+// ansiSpan marks where the SGR (Select Graphic Rendition) state of an item changes. From the rune offset 'start' on,
+// the state is 'sgr', which is a sequence of SGR escape codes to replay (or "" for the default).
+type ansiSpan struct {
+	start int
+	sgr   string
+}
+
+func newState(opts Options) *state {
+	s := &state{
+		opts:       opts,
+		items:      slices.Clone(opts.Items),
+		values:     opts.Values,
+		tiebreaks:  opts.Tiebreaks,
+		styles:     DefaultStyles(),
+		expectKeys: map[string]string{},
+		bindings:   map[string]Action{},
+		index:      opts.Index,
+		log:        opts.Log,
+	}
+	if s.tiebreaks == nil {
+		s.tiebreaks = []string{"length"}
+	}
+	if opts.Styles != nil {
+		s.styles = *opts.Styles
+	}
+	if s.log == nil {
+		s.log = log.New(io.Discard, "", 0)
+	}
+	for _, name := range opts.Expect {
+		s.expectKeys[teaKeyName(name)] = name
+	}
+	for name, a := range DefaultBindings() {
+		s.bindings[teaKeyName(name)] = a
+	}
+	for name, a := range opts.Bindings {
+		s.bindings[teaKeyName(name)] = a
+	}
+
+	if t := opts.Table; t != nil {
+		s.tableHeader, s.tableRows, s.matchColumns = t.Header, t.Rows, t.MatchColumns
+		if s.matchColumns == nil {
+			for i := range s.tableHeader {
+				s.matchColumns = append(s.matchColumns, i)
+			}
+		}
+
+		s.values = nil
+		for _, row := range s.tableRows {
+			var cells []string
+			record := nuon.Value{Kind: nuon.Record}
+			for i, column := range s.tableHeader {
+				record.Record = append(record.Record, nuon.Field{Key: column, Value: nuon.NewString(row[i])})
+			}
+			for _, c := range s.matchColumns {
+				// Newlines are flattened so that each row is one line.
+				cells = append(cells, strings.ReplaceAll(row[c], "\n", " "))
+			}
+			if opts.Items == nil {
+				s.items = append(s.items, strings.Join(cells, "\t"))
+			}
+			s.values = append(s.values, record)
+		}
+	} else if opts.Items == nil {
+		s.items = mapSlice(s.values, func(v nuon.Value, i int) string {
+			if field, ok := v.Get(opts.DisplayField); ok && opts.DisplayField != "" {
+				return field.Text()
+			}
+			return v.Text()
+		})
+	}
+
+	if opts.ANSI && s.tableHeader == nil {
+		s.ansi = true
+		if opts.KeepANSI {
+			s.raw = slices.Clone(s.items)
+		}
+		for i, item := range s.items {
+			var spans []ansiSpan
+			s.items[i], spans = stripANSI(item)
+			s.ansiSpans = append(s.ansiSpans, spans)
+		}
+	}
+
+	if opts.Dedupe {
+		s.collapseDuplicates(0)
+	}
+	if n := s.excess(); n > 0 {
+		s.dropOldest(n)
+	}
+	return s
+}
+
+// The selection for an item.
+func (s *state) selection(item int) Selection {
+	selection := Selection{Index: s.dropped + item, Text: s.items[item], Raw: s.outputText(item)}
+	if s.indices != nil {
+		selection.Index = s.indices[item][0]
+		selection.Indices = s.indices[item]
+	}
+	if s.values != nil {
+		selection.Value = &s.values[item]
+	}
+	if s.tableRows != nil {
+		selection.Row = s.tableRows[item]
+	}
+	return selection
+}
+
+// Collapse the items from 'offset' on that are identical to an earlier item. The first occurrence is kept, and it
+// collects the input indices of the others.
+func (s *state) collapseDuplicates(offset int) {
+	if s.lookup == nil {
+		s.lookup = make(map[string]int)
+	}
+
+	// The item numbers no longer line up with the index.
+	if offset < s.indexed() {
+		s.index = nil
+		s.indexStore.Reset()
+		s.store.Reset()
+	}
+
+	var keep []int
+	for i := offset; i < len(s.items); i++ {
+		// Items are identical if everything that could be output is identical, not only the matched text.
+		key := s.outputText(i)
+		if s.tableRows != nil {
+			key = strings.Join(s.tableRows[i], "\x00")
+		} else if s.values != nil {
+			key = nuon.Format(s.values[i])
+		}
+
+		if j, ok := s.lookup[key]; ok {
+			s.indices[j] = append(s.indices[j], s.inputCount)
+		} else {
+			s.lookup[key] = offset + len(keep)
+			s.indices = append(s.indices, []int{s.inputCount})
+			keep = append(keep, i)
+		}
+		s.inputCount++
+	}
+
+	s.items = keepOnly(s.items, offset, keep)
+	s.values = keepOnly(s.values, offset, keep)
+	s.tableRows = keepOnly(s.tableRows, offset, keep)
+	s.ansiSpans = keepOnly(s.ansiSpans, offset, keep)
+	s.raw = keepOnly(s.raw, offset, keep)
+	s.log.Printf("Collapsed duplicates. %d unique items from %d input items.\n", len(s.items), s.inputCount)
+}
+
+// Keep the first 'offset' elements and then only the elements at the 'keep' indices.
+func keepOnly[T any](s []T, offset int, keep []int) []T {
+	if s == nil {
+		return nil
+	}
+	result := s[:offset:offset]
+	for _, i := range keep {
+		result = append(result, s[i])
+	}
+	return result
+}
+
+// The number of the oldest items to drop to get back to 'MaxItems', or 0 if there aren't enough items to drop yet.
+// Dropping items shifts all the others down, so they are only dropped once there are a quarter of 'MaxItems' too many.
+// This way, each dropped item costs a constant amount of work, however small the batches of new items are.
+func (s *state) excess() int {
+	limit := s.opts.MaxItems
+	if limit <= 0 || len(s.items) <= limit+max(limit/4, 1) {
+		return 0
+	}
+	return len(s.items) - limit
+}
+
+// Drop the 'n' oldest items. The items are shifted down in place, so that the memory stays capped when items keep
+// streaming in (see 'excess').
+func (s *state) dropOldest(n int) {
+	s.items = dropFirst(s.items, n)
+	s.values = dropFirst(s.values, n)
+	s.tableRows = dropFirst(s.tableRows, n)
+	s.ansiSpans = dropFirst(s.ansiSpans, n)
+	s.raw = dropFirst(s.raw, n)
+	s.indices = dropFirst(s.indices, n)
+	// The store has the items after the index, so it's rebuilt when the index goes.
+	if s.index != nil {
+		s.index = nil
+		s.indexStore.Reset()
+		s.store.Reset()
+	} else {
+		s.store.Drop(n)
+	}
+	for key, i := range s.lookup {
+		if i < n {
+			delete(s.lookup, key)
+		} else {
+			s.lookup[key] = i - n
+		}
+	}
+	// The input indices of de-duplicated items count all input items already.
+	if s.indices == nil {
+		s.dropped += n
+	}
+	s.log.Printf("Dropped the %d oldest items. %d items are left.\n", n, len(s.items))
+}
+
+func dropFirst[T any](s []T, n int) []T {
+	if s == nil {
+		return nil
+	}
+	return slices.Delete(s, 0, n)
+}
+
+// The number of times an item occurred in the input. This is 1 unless items are de-duplicated.
+func (s *state) occurrences(item int) int {
+	if s.indices == nil {
+		return 1
+	}
+	return len(s.indices[item])
+}
+
+// The display width of the occurrence count of an item (see 'Options.ShowCount').
+func (s *state) countWidth(item int) int {
+	if !s.opts.ShowCount || s.occurrences(item) <= 1 {
+		return 0
+	}
+	return uniseg.StringWidth(fmt.Sprintf(" ×%d", s.occurrences(item)))
+}
+
+// The text of an item for the output. This is the original text when ANSI codes are to be kept.
+func (s *state) outputText(index int) string {
+	if s.raw != nil {
+		return s.raw[index]
+	}
+	return s.items[index]
+}
+
+// The score bonus for an item's frecency. It grows logarithmically so that frecency re-orders similarly good matches
+// but doesn't bury a much better match.
+func (s *state) frecencyBonus(item string) int {
+	f, ok := s.opts.Frecency[item]
+	if !ok {
+		return 0
+	}
+	return int(8 * math.Log2(1+f))
+}
+
+// The group of an item (see 'GroupBy'). This is the text of the item's group field, or "" if it doesn't have one.
+func (s *state) group(item int) string {
+	if s.values == nil {
+		return ""
+	}
+	if field, ok := s.values[item].Get(s.opts.GroupBy); ok {
+		return field.Text()
+	}
+	return ""
+}
+
+// Build the pattern for the query, according to the matching modes that are turned on.
+//
+// In exact mode, plain terms are substring matches. With typos, fuzzy terms also match with a few typos, and such
+// matches are ranked below the exact ones.
+func buildPattern(m Model) fz.Pattern {
+	var pattern fz.Pattern
+	if m.exact {
+		pattern = fz.BuildExactPattern(m.input.Value())
+	} else {
+		pattern = fz.BuildPattern(m.input.Value())
+	}
+	if m.typos {
+		pattern = pattern.Approximate()
+	}
+	if m.s.opts.MatchingLines {
+		pattern = pattern.PerLine()
+	}
+	return pattern
+}
+
+// Match the items from the given offset onwards against the pattern. Record items are matched as records, so that
+// query terms can be qualified with a field name.
+func (s *state) matchAll(pattern fz.Pattern, offset int) []matchedItem {
+	var matches []matchedItem
+	indexed := s.indexed()
+	if offset < indexed {
+		// Only the candidates from the index can match. If the index can't narrow the pattern down, then all indexed
+		// items are candidates, and they are matched in their own store, like the other items.
+		candidates, ok := s.index.Candidates(pattern)
+		if !ok && s.indexStore.Len() == 0 {
+			for i := range indexed {
+				s.indexStore.Add(s.items[i], s.recordFields(i))
+			}
+		}
+		match := func(i int) {
+			if s.indexStore.Len() > 0 {
+				if ok, m := s.indexStore.Match(pattern, i); ok {
+					matches = append(matches, s.matchedItem(i, m, func(positions []int) int {
+						return s.indexStore.Score(i, positions)
+					}))
+				}
+				return
+			}
+			item := s.items[i]
+			if ok, m := pattern.MatchRecord(item, s.recordFields(i)); ok {
+				matches = append(matches, s.matchedItem(i, m, func(positions []int) int {
+					return fz.Score([]rune(item), positions)
+				}))
+			}
+		}
+		if ok {
+			for _, i := range candidates {
+				if i >= offset {
+					match(i)
+				}
+			}
+		} else {
+			for i := offset; i < indexed; i++ {
+				match(i)
+			}
+		}
+		offset = indexed
+	}
+
+	// The items that aren't in the index are matched in the store. The store only has those items.
+	for i := indexed + s.store.Len(); i < len(s.items); i++ {
+		s.store.Add(s.items[i], s.recordFields(i))
+	}
+	for i := offset; i < len(s.items); i++ {
+		ok, m := s.store.Match(pattern, i-indexed)
+		if !ok {
+			continue
+		}
+		matches = append(matches, s.matchedItem(i, m, func(positions []int) int {
+			return s.store.Score(i-indexed, positions)
+		}))
+	}
+	return matches
+}
+
+// The number of items that are in the index. These items are the first ones.
+func (s *state) indexed() int {
+	if s.index == nil {
+		return 0
+	}
+	return s.index.Len()
+}
+
+// The match of an item, given how it matched and how to score it.
+func (s *state) matchedItem(i int, m fz.RecordMatch, score func(positions []int) int) matchedItem {
+	positions, fieldPositions := m.Positions, m.FieldPositions
+
+	// Highlight the matches in fields that are part of the displayed text.
+	for f, fieldPos := range fieldPositions {
+		offset, ok := s.fieldOffset(i, s.values[i].Record[f].Key)
+		if !ok {
+			continue
+		}
+		for _, pos := range fieldPos {
+			positions = append(positions, offset+pos)
+		}
+	}
+	if fieldPositions != nil {
+		slices.Sort(positions)
+		positions = slices.Compact(positions)
+	}
+
+	return matchedItem{
+		Index:     i,
+		Positions: positions,
+		Score:     score(positions) + s.frecencyBonus(s.items[i]),
+		Edits:     m.Edits,
+	}
+}
+
+// The fields of a record item, for matching. This is nil for items that aren't records.
+func (s *state) recordFields(i int) []fz.Field {
+	if s.values == nil || s.values[i].Kind != nuon.Record {
+		return nil
+	}
+	fields := make([]fz.Field, len(s.values[i].Record))
+	for f, field := range s.values[i].Record {
+		fields[f] = fz.Field{Name: field.Key, Text: field.Value.Text()}
+	}
+	return fields
+}
+
+// The rune offset of a record field's text in the item's displayed text, if the field is displayed.
+func (s *state) fieldOffset(i int, name string) (int, bool) {
+	if s.tableHeader != nil {
+		offset := 0
+		for _, c := range s.matchColumns {
+			if s.tableHeader[c] == name {
+				return offset, true
+			}
+			offset += utf8.RuneCountInString(s.tableRows[i][c]) + 1
+		}
+		return 0, false
+	}
+	return 0, s.opts.DisplayField != "" && name == s.opts.DisplayField
+}
+
+type matchedItem struct {
+	Index     int
+	Positions []int
+	Score     int
+	Edits     int
+}
+
+// Order matches by score (highest first), or first by frequency if 'RankByFrequency' is set, and then by the tiebreak
+// criteria. The input order is the last resort.
+func (s *state) compareMatches(a, b matchedItem) int {
+	if s.opts.RankByFrequency && s.occurrences(a.Index) != s.occurrences(b.Index) {
+		return s.occurrences(b.Index) - s.occurrences(a.Index)
+	}
+	if a.Edits != b.Edits {
+		return a.Edits - b.Edits
+	}
+	if a.Score != b.Score {
+		return b.Score - a.Score
+	}
+
+	for _, criterion := range s.tiebreaks {
+		var x, y int
+		switch criterion {
+		case "length":
+			x, y = utf8.RuneCountInString(s.items[a.Index]), utf8.RuneCountInString(s.items[b.Index])
+		case "begin":
+			x, y = firstOr(a.Positions, 0), firstOr(b.Positions, 0)
+		case "end":
+			x = utf8.RuneCountInString(s.items[a.Index]) - lastOr(a.Positions, 0)
+			y = utf8.RuneCountInString(s.items[b.Index]) - lastOr(b.Positions, 0)
+		case "index":
+			// Input order is the last resort anyway. See below.
+		}
+		if x != y {
+			return x - y
+		}
+	}
+
+	if s.opts.Tac {
+		return b.Index - a.Index
+	}
+	return a.Index - b.Index
+}
+
+// Order items without a query: the most frequent first (see 'RankByFrequency'), then by frecency.
+func (s *state) compareUnmatched(a, b matchedItem) int {
+	if s.opts.RankByFrequency && s.occurrences(a.Index) != s.occurrences(b.Index) {
+		return s.occurrences(b.Index) - s.occurrences(a.Index)
+	}
+	return cmp.Compare(s.opts.Frecency[s.items[b.Index]], s.opts.Frecency[s.items[a.Index]])
+}
+
+func firstOr(s []int, fallback int) int {
+	if len(s) == 0 {
+		return fallback
+	}
+	return s[0]
+}
+
+func lastOr(s []int, fallback int) int {
+	if len(s) == 0 {
+		return fallback
+	}
+	return s[len(s)-1]
+}
+
+func mapSlice[E, T any](items []E, f func(E, int) T) []T {
+	result := make([]T, len(items))
+	for i, item := range items {
+		result[i] = f(item, i)
+	}
+	return result
+}
@@ -0,0 +1,712 @@
+package finder
+
+import (
+	"fmt"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"my-software/pkg/nuon"
+	"slices"
+	"strings"
+)
+
+func (m Model) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	m.s.log.Printf("[Update] tea.Msg: %+v\n", msg)
+	if m.finished {
+		m.s.log.Println("The finder is done. Ignoring the message.")
+		if msg, ok := msg.(remoteMsg); ok {
+			msg.reply <- remoteResponse{Error: "the finder is done"}
+		}
+		return m, nil
+	}
+	var cmds = make([]tea.Cmd, 1)
+
+	// In jump mode, keys pick labels instead of going to the filter input.
+	if msg, ok := msg.(tea.KeyMsg); ok && m.jumping {
+		m.jumping = false
+		i := strings.Index(jumpLabels, msg.String())
+		if len(msg.String()) != 1 || i == -1 || m.offset+i >= m.visibleEnd() {
+			m.s.log.Printf("Key '%s' is not a jump label. Leaving jump mode.\n", msg.String())
+			return m, nil
+		}
+
+		m = moveCursor(m, m.offset+i-m.rank)
+		m.s.log.Printf("Jumped to item %d.\n", m.item)
+		if m.jumpAccept {
+			m.completedWithSelection = true
+			m.finished = true
+			return m, done
+		}
+		return m, nil
+	}
+
+	// Keys that are bound to something else don't go to the filter input. Otherwise, a binding like 'alt-e' would also
+	// type an "e".
+	oldInput := m.input.Value()
+	if msg, ok := msg.(tea.KeyMsg); ok && (m.s.expectKeys[msg.String()] != "" || m.s.bindings[msg.String()].Name != "") {
+		m.s.log.Printf("Key '%s' is bound. Not passing it to the filter input.\n", msg.String())
+	} else {
+		m.input, cmds[0] = m.input.Update(msg)
+	}
+
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		var hz, v int
+		// We want a frame, but only if there is enough space
+		if msg.Height > 10 && msg.Width > 50 {
+			hz, v = m.s.styles.Frame.GetFrameSize()
+			m.frame = m.s.styles.Frame
+		} else {
+			m.frame = lipgloss.NewStyle()
+		}
+
+		m.s.log.Printf("WindowSizeMsg: %+v Frame size: hz=%d, v=%d\n", msg, hz, v)
+		m.height = msg.Height - v
+		m.input.Width = msg.Width - hz - len(m.input.Prompt)
+		// Leave room for the selection box which is two cells wide.
+		m.width = msg.Width - hz - 2
+		if m.s.tableHeader != nil {
+			m.columnWidths = m.s.fitColumns(m.width)
+		}
+		return reflow(m), tea.Batch(cmds...)
+	case tea.KeyMsg:
+		k := msg.String()
+		if name, ok := m.s.expectKeys[k]; ok {
+			m.s.log.Printf("Accepting on expected key '%s'.\n", name)
+			m.completedWithSelection = true
+			m.acceptKey = name
+			m.finished = true
+			cmds = append(cmds, done)
+			return m, tea.Batch(cmds...)
+		}
+
+		if a, ok := m.s.bindings[k]; ok {
+			switch a.Name {
+			case "accept":
+				m.completedWithSelection = true
+				m.finished = true
+				cmds = append(cmds, done)
+			case "abort":
+				m.finished = true
+				cmds = append(cmds, done)
+			case "jump", "jump-accept":
+				if m.item >= 0 {
+					m.jumping = true
+					m.jumpAccept = a.Name == "jump-accept"
+				}
+			case "toggle-sort":
+				m.sort = !m.sort
+				m.s.log.Printf("Toggled sorting. Sorting is now %v.\n", m.sort)
+				return reflow(m), tea.Batch(cmds...)
+			case "toggle-typos":
+				m.typos = !m.typos
+				m.s.log.Printf("Toggled typo-tolerant matching. It is now %v.\n", m.typos)
+				return filter(updatePrompt(m)), tea.Batch(cmds...)
+			case "toggle-exact":
+				m.exact = !m.exact
+				m.s.log.Printf("Toggled exact matching. It is now %v.\n", m.exact)
+				return filter(updatePrompt(m)), tea.Batch(cmds...)
+			case "reload":
+				m.s.log.Printf("Reloading items from command '%s'...\n", a.Arg)
+				// A newer reload supersedes any reload that is still streaming in.
+				m.reloadGeneration++
+				if m.item >= 0 {
+					value := m.s.items[m.item]
+					m.reloadValue = &value
+				}
+				cmds = append(cmds, startReload(m.reloadGeneration, a.Arg))
+			}
+			return m, tea.Batch(cmds...)
+		}
+
+		switch k {
+		case "ctrl+c", "esc":
+			m.finished = true
+			cmds = append(cmds, done)
+			return m, tea.Batch(cmds...)
+		case "enter":
+			m.completedWithSelection = true
+			m.finished = true
+			cmds = append(cmds, done)
+			return m, tea.Batch(cmds...)
+		case "up":
+			m.s.log.Println("Handling key press 'up'...")
+			return moveCursor(m, -1), tea.Batch(cmds...)
+		case "down":
+			m.s.log.Println("Handling key press 'down'...")
+			return moveCursor(m, 1), tea.Batch(cmds...)
+		case "pgup":
+			m.s.log.Println("Handling key press 'pgup'...")
+			return scrollPage(m, -1), tea.Batch(cmds...)
+		case "pgdown":
+			m.s.log.Println("Handling key press 'pgdown'...")
+			return scrollPage(m, 1), tea.Batch(cmds...)
+		default: // Assume some text was entered in the filter input.
+			newInput := m.input.Value()
+			if oldInput != newInput {
+				m.s.log.Printf("[Update] Filter changed. Was '%+v', now '%+v'. Must re-execute fuzzy finding and re-flow the list...\n", oldInput, newInput)
+				return filter(m), tea.Batch(cmds...)
+			}
+
+			return m, tea.Batch(cmds...)
+		}
+	case reloadMsg:
+		if msg.generation != m.reloadGeneration {
+			m.s.log.Printf("Discarding items from a superseded reload (generation %d).\n", msg.generation)
+			if msg.cmd != nil {
+				msg.cmd.Process.Kill()
+				// Reap the process, so that it doesn't linger as a zombie.
+				go msg.cmd.Wait()
+			}
+			return m, tea.Batch(cmds...)
+		}
+
+		if msg.err != nil {
+			m.s.log.Printf("The reload command failed: %v\n", msg.err)
+			// A command that failed without any output, like one that couldn't be started, has nothing to replace the
+			// items with.
+			if msg.first && len(msg.items) == 0 {
+				m.reloadValue = nil
+				return m, tea.Batch(cmds...)
+			}
+		}
+
+		m = addItems(m, msg.items, msg.first)
+		if msg.next != nil {
+			cmds = append(cmds, msg.next)
+		} else {
+			m.reloadValue = nil
+		}
+		return m, tea.Batch(cmds...)
+	case itemsMsg:
+		return addItems(m, msg.items, false), tea.Batch(cmds...)
+	case remoteMsg:
+		m.s.log.Printf("Handling remote request: %+v\n", msg.request)
+		response := remoteResponse{OK: true}
+		switch msg.request.Op {
+		case "append":
+			m = addItems(m, msg.request.Items, false)
+		case "replace":
+			// Like a reload, this supersedes any reload that is still streaming in.
+			m.reloadGeneration++
+			if m.item >= 0 {
+				value := m.s.items[m.item]
+				m.reloadValue = &value
+			}
+			m = addItems(m, msg.request.Items, true)
+			m.reloadValue = nil
+		case "query":
+			m.input.SetValue(msg.request.Query)
+			m = filter(m)
+		case "move":
+			m = moveCursor(m, msg.request.By)
+		case "state":
+			state := remoteState{Query: m.input.Value(), Matches: []remoteItem{}}
+			for _, match := range m.list {
+				state.Matches = append(state.Matches, m.s.remoteItem(match.Index))
+			}
+			if m.item >= 0 {
+				selected := m.s.remoteItem(m.item)
+				state.Selected = &selected
+			}
+			response.State = &state
+		case "accept":
+			m.completedWithSelection = true
+			m.finished = true
+			cmds = append(cmds, done)
+		case "abort":
+			m.finished = true
+			cmds = append(cmds, done)
+		default:
+			response = remoteResponse{Error: fmt.Sprintf("unknown operation '%s'", msg.request.Op)}
+		}
+		msg.reply <- response
+		return m, tea.Batch(cmds...)
+	default:
+		m.s.log.Printf("Unexpected message: %+v\n", msg)
+		return m, tea.Batch(cmds...)
+	}
+}
+
+// Move the cursor by 'delta' items. A negative delta moves up. The cursor stops at the first and last items.
+func moveCursor(m Model, delta int) Model {
+	if m.item == -1 {
+		m.s.log.Println("There are no items to select. Moving the cursor is a no-op.")
+		return m
+	}
+
+	m.rank = min(max(m.rank+delta, 0), len(m.list)-1)
+	m.item = m.list[m.rank].Index
+	return scroll(m)
+}
+
+// Scroll the viewport by about a screen (see 'listHeight'). A negative direction scrolls up. The cursor moves along by
+// the same number of items so that it stays at the same place on the screen, if possible.
+func scrollPage(m Model, direction int) Model {
+	if m.item == -1 {
+		return m
+	}
+
+	n := 0
+	if direction > 0 {
+		n = max(m.visibleEnd()-m.offset, 1)
+		if m.offset+n >= len(m.list) {
+			return moveCursor(m, len(m.list))
+		}
+	} else {
+		for budget := m.listHeight(); m.offset-n > 0 && m.heights[m.offset-n-1] <= budget; n++ {
+			budget -= m.heights[m.offset-n-1]
+		}
+		if m.offset-n <= 0 {
+			return moveCursor(m, -len(m.list))
+		}
+		n = -max(n, 1)
+	}
+
+	m.offset += n
+	return moveCursor(m, n)
+}
+
+// Re-execute fuzzy finding for the current filter input and re-flow the list.
+func filter(m Model) Model {
+	if m.input.Value() == "" {
+		m.s.log.Println("No input. Skip fuzzy matching.")
+		m.matches = nil
+	} else {
+		// Use "fzf" (https://github.com/junegunn/fzf) to filter through the list.
+		//
+		//"fzf" is not available as a library (https://github.com/junegunn/fzf/pull/1053#issuecomment-330024275),
+		// which is totally fine. While there are other Go-based fuzzy finders, I want the power and API of
+		// "fzf". To make it work, I copied (should I say "vendored"?) the code I needed from the "fzf"
+		// codebase into this codebase.
+		//
+		// From a TUI perspective, this is a "dirty programming pattern" because this is a relatively slow
+		// operation, and we're doing it on the UI thread. You are "supposed" to use a Go routine and
+		//message passing. But in practice, it's exactly what I want.
+		matches := m.s.matchAll(buildPattern(m), 0)
+		m.matches = matches
+	}
+	return reflow(m)
+}
+
+// Add items to the master list, or replace the master list if 'replace' is set. Only the new items are matched against
+// the current filter input.
+//
+// If 'm.reloadValue' is set, then the cursor is restored to the first new item with that same value and
+// 'm.reloadValue' is cleared. Otherwise, the reflow falls back to the item closest to the old index.
+func addItems(m Model, items []string, replace bool) Model {
+	offset := len(m.s.items)
+	if replace {
+		offset = 0
+		m.s.items = nil
+		m.s.store.Reset()
+		m.s.index = nil
+		m.s.indexStore.Reset()
+		m.s.dropped = 0
+		m.matches = nil
+	}
+	if m.s.ansi {
+		if replace {
+			m.s.ansiSpans = nil
+			if m.s.raw != nil {
+				m.s.raw = []string{}
+			}
+		}
+		stripped := make([]string, len(items))
+		for i, item := range items {
+			var spans []ansiSpan
+			stripped[i], spans = stripANSI(item)
+			m.s.ansiSpans = append(m.s.ansiSpans, spans)
+			if m.s.raw != nil {
+				m.s.raw = append(m.s.raw, item)
+			}
+		}
+		items = stripped
+	}
+	m.s.items = append(m.s.items, items...)
+	structured := m.s.values != nil
+	if replace {
+		m.s.values = nil
+	}
+	if m.s.tableHeader != nil {
+		// The new items aren't parsed as rows. Each one goes in the first match column.
+		if replace {
+			m.s.tableRows = nil
+		}
+		for _, item := range items {
+			row := make([]string, len(m.s.tableHeader))
+			row[m.s.matchColumns[0]] = item
+			m.s.tableRows = append(m.s.tableRows, row)
+			record := nuon.Value{Kind: nuon.Record}
+			for i, column := range m.s.tableHeader {
+				record.Record = append(record.Record, nuon.Field{Key: column, Value: nuon.NewString(row[i])})
+			}
+			m.s.values = append(m.s.values, record)
+		}
+	} else if structured {
+		for _, item := range items {
+			m.s.values = append(m.s.values, nuon.NewString(item))
+		}
+	}
+	if m.s.opts.Dedupe {
+		if replace {
+			m.s.indices = nil
+			m.s.lookup = nil
+			m.s.inputCount = 0
+		}
+		m.s.collapseDuplicates(offset)
+	}
+	m.s.log.Printf("Added %d items (%d total).\n", len(items), len(m.s.items))
+
+	if n := m.s.excess(); n > 0 {
+		m.s.dropOldest(n)
+		m = dropMatches(m, n)
+		offset = max(offset-n, 0)
+	}
+
+	if m.input.Value() != "" {
+		m.matches = append(m.matches, m.s.matchAll(buildPattern(m), offset)...)
+	}
+
+	if m.reloadValue != nil {
+		if i := slices.Index(m.s.items[offset:], *m.reloadValue); i >= 0 {
+			m.item = offset + i
+			m.reloadValue = nil
+		}
+	}
+
+	return reflow(m)
+}
+
+// Drop the matches of the 'n' oldest items, which were dropped from the master list, and shift the rest down. The
+// cursor and the viewport stay on the same items, if they are still there.
+func dropMatches(m Model, n int) Model {
+	var matches []matchedItem
+	for _, match := range m.matches {
+		if match.Index >= n {
+			match.Index -= n
+			matches = append(matches, match)
+		}
+	}
+	m.matches = matches
+
+	dropped := func(ranks []matchedItem) int {
+		count := 0
+		for _, match := range ranks {
+			if match.Index < n {
+				count++
+			}
+		}
+		return count
+	}
+	if m.rank >= 0 && m.rank < len(m.list) {
+		m.offset -= dropped(m.list[:m.offset])
+		m.rank -= dropped(m.list[:m.rank])
+	}
+	m.item = max(m.item-n, -1)
+	return m
+}
+
+func (m Model) View() string {
+	m.s.log.Println("[View]")
+	var (
+		sections    []string
+		availHeight = m.height
+	)
+
+	v := m.input.View()
+	sections = append(sections, v)
+	availHeight -= lipgloss.Height(v)
+
+	if m.s.tableHeader != nil {
+		header := m.s.styles.TableHeader.Render(renderRow(m.s.tableHeader, nil, nil, lipgloss.NewStyle(), m.columnWidths))
+		sections = append(sections, header)
+		availHeight -= lipgloss.Height(header)
+	}
+
+	content := lipgloss.NewStyle().Height(availHeight).Render(m.populatedView())
+	sections = append(sections, content)
+	return m.frame.Render(lipgloss.JoinVertical(lipgloss.Left, sections...))
+}
+
+// Set the prompt to show which matching modes are turned on.
+func updatePrompt(m Model) Model {
+	var modes []string
+	if m.exact {
+		modes = append(modes, "exact")
+	}
+	if m.typos {
+		modes = append(modes, "typos")
+	}
+	prompt := "Filter: "
+	if modes != nil {
+		prompt = fmt.Sprintf("Filter (%s): ", strings.Join(modes, ", "))
+	}
+	if m.input.Width > 0 {
+		m.input.Width += len(m.input.Prompt) - len(prompt)
+	}
+	m.input.Prompt = prompt
+	return m
+}
+
+func (m Model) FilterValue() string {
+	return m.input.Value()
+}
+
+// "Reflow" the matches into the list that is displayed: sort them and measure how many lines each one takes up. Many
+// one-line items fit in the viewport whereas multi-line items take up more space.
+//
+// This function also re-calculates the selected item and scrolls the viewport to it.
+func reflow(m Model) Model {
+	var matches []matchedItem
+	if m.input.Value() == "" {
+		m.s.log.Println("No input. Create fake matches for all items so that the list can get created.")
+		matches = mapSlice(m.s.items, func(item string, i int) matchedItem {
+			return matchedItem{Index: i}
+		})
+	} else {
+		m.s.log.Printf("Reflowing against %d matches...\n", len(m.matches))
+		matches = m.matches
+	}
+
+	// The matches are in input order. Sort them by score when there is a query, unless sorting is turned off.
+	sorted := m.sort && m.input.Value() != ""
+	if sorted {
+		matches = slices.Clone(matches)
+		slices.SortStableFunc(matches, m.s.compareMatches)
+	} else if m.sort && (m.s.opts.RankByFrequency || m.s.opts.Frecency != nil) {
+		matches = slices.Clone(matches)
+		if m.s.opts.Tac {
+			slices.Reverse(matches)
+		}
+		slices.SortStableFunc(matches, m.s.compareUnmatched)
+		sorted = true
+	} else if m.s.opts.Tac {
+		matches = slices.Clone(matches)
+		slices.Reverse(matches)
+	}
+
+	// Keep the matches of each group together. The groups are in the order of their first (best) match, and the order
+	// within each group is kept.
+	grouped := m.s.opts.GroupBy != "" && m.s.values != nil
+	if grouped {
+		order := make(map[string]int)
+		groupOf := make(map[int]int, len(matches))
+		for _, match := range matches {
+			group := m.s.group(match.Index)
+			if _, ok := order[group]; !ok {
+				order[group] = len(order)
+			}
+			groupOf[match.Index] = order[group]
+		}
+		matches = slices.Clone(matches)
+		slices.SortStableFunc(matches, func(a, b matchedItem) int {
+			return groupOf[a.Index] - groupOf[b.Index]
+		})
+	}
+
+	// There may be no matches for the query, or no items at all (e.g. a reload produced no output).
+	if len(matches) == 0 {
+		m.s.log.Println("No matches were found. There is nothing to reflow.")
+		m.item = -1
+		m.list = nil
+		m.heights = nil
+		m.headers = nil
+		m.rank = -1
+		m.offset = 0
+		return m
+	}
+
+	// Restore the cursor to the previously selected item if it's still there. Otherwise, when the matches are sorted,
+	// restore the cursor to the same rank in the list because the order may have changed entirely. When the matches are
+	// in input order, restore the cursor to the item with the closest index. If nothing was selected before, select the
+	// first item.
+	prevItem := m.item
+	prevRank := m.rank
+	byRank := sorted
+	if prevRank < 0 || prevRank >= len(m.list) {
+		prevItem = -1
+		prevRank = 0
+		byRank = true
+	}
+	prevRank = min(prevRank, len(matches)-1)
+	closestDistance := len(m.s.items)
+	found := false
+
+	heights := make([]int, len(matches))
+	headers := make([]bool, len(matches))
+	for rank, match := range matches {
+		item := m.s.items[match.Index]
+		if m.s.tableHeader == nil && (m.s.opts.Wrap || m.s.opts.MatchingLines) {
+			// Fit the item like it's rendered, next to its count (see 'populatedView').
+			item, _ = m.s.fitMatch(match, m.width-m.s.countWidth(match.Index))
+		}
+		heights[rank] = lipgloss.Height(item)
+		// The header of a group takes up a line above the group's first item. It's not an item of its own, so the
+		// cursor can't land on it.
+		if grouped && (rank == 0 || m.s.group(match.Index) != m.s.group(matches[rank-1].Index)) {
+			headers[rank] = true
+			heights[rank]++
+		}
+
+		if found {
+			continue
+		}
+
+		distance := prevItem - match.Index
+		if distance < 0 {
+			distance = -distance
+		}
+		if byRank {
+			found = distance == 0
+			if !found && rank != prevRank {
+				continue
+			}
+		} else if distance >= closestDistance {
+			continue
+		}
+
+		m.item = match.Index
+		m.rank = rank
+		closestDistance = distance
+	}
+
+	m.list = matches
+	m.heights = heights
+	m.headers = headers
+	return scroll(m)
+}
+
+// The number of lines available to the list of items.
+func (m Model) listHeight() int {
+	height := m.height - lipgloss.Height(m.input.View())
+	if m.s.tableHeader != nil {
+		height--
+	}
+	return height
+}
+
+// The rank after the last item that is (at least partly) visible in the viewport.
+func (m Model) visibleEnd() int {
+	end := m.offset
+	for budget := m.listHeight(); end < len(m.list) && budget > 0; end++ {
+		budget -= m.heights[end]
+	}
+	return end
+}
+
+// Scroll the viewport so that the cursor is visible, with a margin of 'ScrollOff' lines above and below it where
+// possible. The viewport scrolls as little as possible, like in Vim, instead of jumping a whole screen at a time.
+func scroll(m Model) Model {
+	if m.rank < 0 {
+		m.offset = 0
+		return m
+	}
+
+	avail := m.listHeight()
+	lines := func(from, to int) int {
+		n := 0
+		for _, h := range m.heights[from:to] {
+			n += h
+		}
+		return n
+	}
+	// A tall item can't have the full margin on both sides.
+	margin := min(m.s.opts.ScrollOff, max(0, (avail-m.heights[m.rank])/2))
+
+	m.offset = min(m.offset, m.rank)
+	for m.offset > 0 && lines(m.offset, m.rank) < margin {
+		m.offset--
+	}
+	below := min(margin, lines(m.rank+1, len(m.list)))
+	for m.offset < m.rank && lines(m.offset, m.rank+1)+below > avail {
+		m.offset++
+	}
+	// Don't leave empty space at the bottom when there are items above the viewport.
+	for m.offset > 0 && lines(m.offset-1, len(m.list)) <= avail {
+		m.offset--
+	}
+	m.s.log.Printf("[scroll] rank=%d offset=%d avail=%d\n", m.rank, m.offset, avail)
+	return m
+}
+
+func (m Model) populatedView() string {
+	m.s.log.Println("[populatedView]")
+
+	var b strings.Builder
+
+	if len(m.list) == 0 {
+		return m.s.styles.NoItems.Render("No matches.")
+	}
+
+	matches := m.list[m.offset:m.visibleEnd()]
+
+	for i, match := range matches {
+		item := m.s.items[match.Index]
+
+		var style lipgloss.Style
+		var blockStyle lipgloss.Style
+
+		if m.offset+i == m.rank {
+			style = m.s.styles.SelectedItem
+			blockStyle = m.s.styles.SelectedItemBox
+		} else {
+			style = m.s.styles.Item
+			blockStyle = m.s.styles.ItemBox
+		}
+
+		if m.s.tableHeader != nil {
+			item = renderRow(m.s.tableRows[match.Index], m.s.matchColumns, match.Positions, style, m.columnWidths)
+		} else {
+			var source []int
+			item, source = m.s.fitMatch(match, m.width-m.s.countWidth(match.Index))
+			if m.s.ansi && m.s.ansiSpans[match.Index] != nil {
+				item = renderANSI(item, source, remapPositions(source, match.Positions), m.s.ansiSpans[match.Index])
+			} else {
+				item = underlineMatches(item, remapPositions(source, match.Positions), style)
+			}
+		}
+		if m.s.countWidth(match.Index) > 0 && m.s.tableHeader == nil {
+			lines := strings.SplitN(item, "\n", 2)
+			lines[0] += m.s.styles.Count.Render(fmt.Sprintf(" ×%d", m.s.occurrences(match.Index)))
+			item = strings.Join(lines, "\n")
+		}
+
+		if m.jumping && i < len(jumpLabels) {
+			// The label takes the place of the two-cell gutter.
+			lines := strings.Split(item, "\n")
+			for j := range lines {
+				if j == 0 {
+					lines[j] = m.s.styles.JumpLabel.Render(jumpLabels[i:i+1]) + " " + lines[j]
+				} else {
+					lines[j] = "  " + lines[j]
+				}
+			}
+			item = strings.Join(lines, "\n")
+		} else {
+			item = blockStyle.Render(item)
+		}
+
+		if m.headers[m.offset+i] {
+			group := m.s.group(match.Index)
+			if group == "" {
+				group = fmt.Sprintf("(no %s)", m.s.opts.GroupBy)
+			}
+			item = m.s.styles.GroupHeader.Render(group) + "\n" + item
+		}
+
+		if i != len(matches)-1 {
+			item = item + "\n"
+		}
+
+		fmt.Fprintf(&b, "%s", item)
+	}
+
+	// The last item may be only partly visible.
+	lines := strings.Split(b.String(), "\n")
+	return strings.Join(lines[:min(len(lines), max(m.listHeight(), 1))], "\n")
+}
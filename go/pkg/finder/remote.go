@@ -0,0 +1,173 @@
+package finder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"io"
+	"log"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// The number of lines to read from a reload command before handing them to the UI. The UI shows items as they arrive
+// instead of waiting for the command to finish.
+const reloadChunkSize = 1000
+
+// reloadMsg carries a chunk of items read from the command of a 'reload' action. The first chunk replaces the items
+// and the rest are appended. If there is more to read, 'next' reads the next chunk, and 'cmd' is the command that is
+// still running.
+type reloadMsg struct {
+	generation int
+	first      bool
+	items      []string
+	err        error
+	cmd        *exec.Cmd
+	next       tea.Cmd
+}
+
+// itemsMsg carries a batch of items that streamed in (see 'Options.Stream').
+type itemsMsg struct {
+	items []string
+}
+
+// remoteMsg carries a request from a remote-control client (see 'Options.Listener'). The response is sent back
+// over the 'reply' channel. Handling the request in 'Update' keeps all model changes on the UI thread.
+type remoteMsg struct {
+	request remoteRequest
+	reply   chan remoteResponse
+}
+
+// remoteRequest is one line of JSON sent by a remote-control client. The supported operations are:
+//   - {"op": "append", "items": ["a", "b"]}
+//   - {"op": "replace", "items": ["a", "b"]}
+//   - {"op": "query", "query": "abc"}
+//   - {"op": "move", "by": 1} (negative values move up)
+//   - {"op": "state"}
+//   - {"op": "accept"}
+//   - {"op": "abort"}
+type remoteRequest struct {
+	Op    string   `json:"op"`
+	Items []string `json:"items,omitempty"`
+	Query string   `json:"query,omitempty"`
+	By    int      `json:"by,omitempty"`
+}
+
+type remoteResponse struct {
+	OK    bool         `json:"ok"`
+	Error string       `json:"error,omitempty"`
+	State *remoteState `json:"state,omitempty"`
+}
+
+type remoteState struct {
+	Query    string       `json:"query"`
+	Matches  []remoteItem `json:"matches"`
+	Selected *remoteItem  `json:"selected"`
+}
+
+// remoteItem is an item in the state reported to remote-control clients. This is the same shape as the JSON output of
+// the 'my-fuzzy-finder' program.
+type remoteItem struct {
+	// The index of the item in the input. For de-duplicated items, this is the index of the first occurrence.
+	Index int `json:"index"`
+	// For de-duplicated items, the indices of all occurrences in the input.
+	Indices []int `json:"indices,omitempty"`
+	// The item text
+	Value string `json:"value"`
+}
+
+func (s *state) remoteItem(item int) remoteItem {
+	selection := s.selection(item)
+	return remoteItem{Index: selection.Index, Indices: selection.Indices, Value: selection.Text}
+}
+
+// Accept remote-control connections. Each connection sends requests as lines of JSON and receives one line of JSON in
+// response to each request. The program doesn't handle any more requests once 'done' is closed, so connections stop
+// waiting for responses then.
+func serve(listener net.Listener, p *tea.Program, done <-chan struct{}, logger *log.Logger) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			logger.Printf("Stopped accepting remote-control connections: %v\n", err)
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			decoder := json.NewDecoder(conn)
+			encoder := json.NewEncoder(conn)
+			for {
+				var request remoteRequest
+				if err := decoder.Decode(&request); err != nil {
+					if err != io.EOF {
+						encoder.Encode(remoteResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+					}
+					return
+				}
+
+				reply := make(chan remoteResponse, 1)
+				p.Send(remoteMsg{request: request, reply: reply})
+				select {
+				case response := <-reply:
+					if err := encoder.Encode(response); err != nil {
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Convert a key name spelled the fzf way (e.g. "ctrl-o" or "pgdn") to the Bubble Tea way (e.g. "ctrl+o" or "pgdown").
+func teaKeyName(name string) string {
+	if name == "-" {
+		return name
+	}
+	name = strings.ReplaceAll(name, "-", "+")
+	if rest, ok := strings.CutSuffix(name, "pgdn"); ok && (rest == "" || strings.HasSuffix(rest, "+")) {
+		name = rest + "pgdown"
+	}
+	return name
+}
+
+// Start the command of a 'reload' action and read the first chunk of its output lines.
+func startReload(generation int, command string) tea.Cmd {
+	return func() tea.Msg {
+		cmd := exec.Command("sh", "-c", command)
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return reloadMsg{generation: generation, first: true, err: err}
+		}
+		if err := cmd.Start(); err != nil {
+			return reloadMsg{generation: generation, first: true, err: err}
+		}
+
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(nil, 1024*1024)
+		return readReloadChunk(generation, cmd, scanner, true)
+	}
+}
+
+func readReloadChunk(generation int, cmd *exec.Cmd, scanner *bufio.Scanner, first bool) tea.Msg {
+	msg := reloadMsg{generation: generation, first: first, cmd: cmd}
+	for len(msg.items) < reloadChunkSize {
+		if !scanner.Scan() {
+			msg.err = scanner.Err()
+			if err := cmd.Wait(); msg.err == nil {
+				msg.err = err
+			}
+			msg.cmd = nil
+			return msg
+		}
+		msg.items = append(msg.items, scanner.Text())
+	}
+
+	msg.next = func() tea.Msg {
+		return readReloadChunk(generation, cmd, scanner, false)
+	}
+	return msg
+}
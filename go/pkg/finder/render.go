@@ -0,0 +1,521 @@
+package finder
+
+import (
+	"fmt"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/rivo/uniseg"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Compute the display widths of the table columns so that they fit within the given width. Each column gets its
+// natural width if possible. Otherwise, the widest columns are narrowed first, down to a minimum width, and their cells
+// get truncated with an ellipsis.
+func (s *state) fitColumns(width int) []int {
+	const gap = 2
+	const minWidth = 3
+
+	widths := make([]int, len(s.tableHeader))
+	for c, column := range s.tableHeader {
+		widths[c] = uniseg.StringWidth(column)
+		for _, row := range s.tableRows {
+			widths[c] = max(widths[c], uniseg.StringWidth(row[c]))
+		}
+	}
+
+	total := gap * (len(widths) - 1)
+	for _, w := range widths {
+		total += w
+	}
+	for total > width {
+		widest := 0
+		for c, w := range widths {
+			if w > widths[widest] {
+				widest = c
+			}
+		}
+		if widths[widest] <= minWidth {
+			break
+		}
+		widths[widest]--
+		total--
+	}
+	return widths
+}
+
+// Render a table row as aligned cells. The match positions are rune offsets into the tab-joined cells of the match
+// columns (this is the item text that was matched against).
+func renderRow(row []string, columns []int, positions []int, style lipgloss.Style, widths []int) string {
+	starts := make(map[int]int)
+	offset := 0
+	for _, c := range columns {
+		starts[c] = offset
+		offset += utf8.RuneCountInString(row[c]) + 1
+	}
+
+	var b strings.Builder
+	for c, cell := range row {
+		if c >= len(widths) {
+			break
+		}
+		if c > 0 {
+			b.WriteString(style.Render("  "))
+		}
+
+		var cellPositions []int
+		if start, ok := starts[c]; ok {
+			for _, p := range positions {
+				if p >= start && p < start+utf8.RuneCountInString(cell) {
+					cellPositions = append(cellPositions, p-start)
+				}
+			}
+		}
+
+		// Flatten newlines and tabs so the row stays on one line. This preserves rune offsets.
+		cell = strings.NewReplacer("\n", " ", "\t", " ").Replace(cell)
+		cell, source := fitLine(cell, cellPositions, widths[c], false)
+		b.WriteString(underlineMatches(cell, remapPositions(source, cellPositions), style))
+		if pad := widths[c] - uniseg.StringWidth(cell); pad > 0 {
+			b.WriteString(style.Render(strings.Repeat(" ", pad)))
+		}
+	}
+	return b.String()
+}
+
+// Fit each line of an item into 'width' display cells, either by wrapping or by truncating with an ellipsis. The match
+// positions are rune offsets into the item, and they're used to keep the first match of each line visible.
+//
+// Besides the fitted text, this returns the source of each rune of the fitted text: its rune offset in the item, or -1
+// for an inserted ellipsis or line break. Use this to carry match positions (see 'remapPositions') and other per-rune
+// information over to the fitted text.
+func fitItem(item string, positions []int, width int, wrap bool) (string, []int) {
+	var (
+		out       strings.Builder
+		source    []int
+		lineStart int
+	)
+	for i, line := range strings.Split(item, "\n") {
+		lineLength := utf8.RuneCountInString(line)
+		var linePositions []int
+		for _, p := range positions {
+			if p >= lineStart && p < lineStart+lineLength {
+				linePositions = append(linePositions, p-lineStart)
+			}
+		}
+
+		if i > 0 {
+			out.WriteString("\n")
+			source = append(source, lineStart-1)
+		}
+		line, lineSource := fitLine(line, linePositions, width, wrap)
+		out.WriteString(line)
+		for _, src := range lineSource {
+			if src >= 0 {
+				src += lineStart
+			}
+			source = append(source, src)
+		}
+
+		lineStart += lineLength + 1
+	}
+	return out.String(), source
+}
+
+// Fit a matched item for display (see 'fitItem'). With 'MatchingLines', a multi-line item is first collapsed to its
+// matching lines (see 'condenseLines').
+func (s *state) fitMatch(match matchedItem, width int) (string, []int) {
+	item := s.items[match.Index]
+	if !s.opts.MatchingLines || match.Positions == nil || !strings.Contains(item, "\n") {
+		return fitItem(item, match.Positions, width, s.opts.Wrap)
+	}
+
+	condensed, lineSource := condenseLines(item, match.Positions, s.opts.Context)
+	fitted, source := fitItem(condensed, remapPositions(lineSource, match.Positions), width, s.opts.Wrap)
+	for i, src := range source {
+		if src >= 0 {
+			source[i] = lineSource[src]
+		}
+	}
+	return fitted, source
+}
+
+// Collapse a multi-line item to the lines that have matches, plus 'context' lines of context around them. The
+// lines are numbered, and a run of skipped lines is shown as a '┆' line.
+//
+// Like 'fitItem', this returns the source of each rune of the collapsed text, with -1 for the inserted line numbers.
+func condenseLines(item string, positions []int, context int) (string, []int) {
+	lines := strings.Split(item, "\n")
+	starts := make([]int, len(lines))
+	start := 0
+	for i, line := range lines {
+		starts[i] = start
+		start += utf8.RuneCountInString(line) + 1
+	}
+
+	shown := make([]bool, len(lines))
+	for _, p := range positions {
+		i, found := slices.BinarySearch(starts, p)
+		if !found {
+			i--
+		}
+		for j := max(0, i-context); j <= min(len(lines)-1, i+context); j++ {
+			shown[j] = true
+		}
+	}
+
+	var (
+		out    strings.Builder
+		source []int
+	)
+	inserted := func(text string) {
+		out.WriteString(text)
+		for range []rune(text) {
+			source = append(source, -1)
+		}
+	}
+	numberWidth := len(strconv.Itoa(len(lines)))
+	gap := fmt.Sprintf("%*s┆", numberWidth, "")
+	skipped := false
+	for i, line := range lines {
+		if !shown[i] {
+			skipped = true
+			continue
+		}
+		if out.Len() > 0 {
+			inserted("\n")
+		}
+		if skipped {
+			inserted(gap + "\n")
+			skipped = false
+		}
+		inserted(fmt.Sprintf("%*d│ ", numberWidth, i+1))
+		out.WriteString(line)
+		for j := range utf8.RuneCountInString(line) {
+			source = append(source, starts[i]+j)
+		}
+	}
+	if skipped {
+		inserted("\n" + gap)
+	}
+	return out.String(), source
+}
+
+// Fit one line of text into 'width' display cells. This is like 'fitItem' but for a line.
+//
+// When wrapping, the line is broken into multiple lines. When truncating, the overflow is replaced with an ellipsis.
+// If the first match would be cut off, then the visible window is scrolled right (with a leading ellipsis) so that the
+// match is visible, like fzf does.
+//
+// Widths are measured per grapheme cluster, so wide characters (CJK, emoji) count as two cells and multi-rune emoji
+// sequences are never split.
+func fitLine(line string, positions []int, width int, wrap bool) (string, []int) {
+	if width <= 0 || uniseg.StringWidth(line) <= width {
+		source := make([]int, utf8.RuneCountInString(line))
+		for i := range source {
+			source[i] = i
+		}
+		return line, source
+	}
+
+	type cluster struct {
+		start int // Rune offset
+		runes []rune
+		width int
+	}
+	var clusters []cluster
+	offset := 0
+	graphemes := uniseg.NewGraphemes(line)
+	for graphemes.Next() {
+		runes := graphemes.Runes()
+		clusters = append(clusters, cluster{start: offset, runes: runes, width: graphemes.Width()})
+		offset += len(runes)
+	}
+
+	var (
+		b      strings.Builder
+		source []int
+	)
+	write := func(c cluster) {
+		b.WriteString(string(c.runes))
+		for i := range c.runes {
+			source = append(source, c.start+i)
+		}
+	}
+
+	if wrap {
+		used := 0
+		for _, c := range clusters {
+			if used+c.width > width && used > 0 {
+				b.WriteString("\n")
+				source = append(source, -1)
+				used = 0
+			}
+			write(c)
+			used += c.width
+		}
+		return b.String(), source
+	}
+
+	const ellipsis = "…"
+
+	// Find the cluster of the first match.
+	first := 0
+	if len(positions) > 0 {
+		for i, c := range clusters {
+			if positions[0] < c.start+len(c.runes) {
+				first = i
+				break
+			}
+		}
+	}
+
+	// Scroll right only if the first match doesn't fit next to the trailing ellipsis. Keep up to a third of the width
+	// as context before the match.
+	from := 0
+	prefixWidth := 0
+	for _, c := range clusters[:first+1] {
+		prefixWidth += c.width
+	}
+	if prefixWidth > width-1 {
+		from = first
+		budget := width / 3
+		for from > 0 && clusters[from-1].width <= budget {
+			budget -= clusters[from-1].width
+			from--
+		}
+	}
+
+	used := 0
+	if from > 0 {
+		b.WriteString(ellipsis)
+		source = append(source, -1)
+		used++
+	}
+	// The width of the clusters from each cluster to the end of the line.
+	remaining := make([]int, len(clusters)+1)
+	for i := len(clusters) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + clusters[i].width
+	}
+	to := from
+	for to < len(clusters) {
+		if used+remaining[to] <= width {
+			to = len(clusters)
+			break
+		}
+		if used+clusters[to].width > width-1 {
+			break
+		}
+		used += clusters[to].width
+		to++
+	}
+	for _, c := range clusters[from:to] {
+		write(c)
+	}
+	if to < len(clusters) {
+		b.WriteString(ellipsis)
+		source = append(source, -1)
+	}
+	return b.String(), source
+}
+
+// Re-map match positions to rune offsets into fitted text, given the source of each fitted rune (see 'fitItem').
+// Positions that were cut off are dropped.
+func remapPositions(source []int, positions []int) []int {
+	matched := make(map[int]bool, len(positions))
+	for _, p := range positions {
+		matched[p] = true
+	}
+
+	var remapped []int
+	for i, src := range source {
+		if src >= 0 && matched[src] {
+			remapped = append(remapped, i)
+		}
+	}
+	return remapped
+}
+
+// Whether the parameters of an SGR code reset the attributes, and whether that's all they do. The attributes are reset
+// by an empty parameter list or by a parameter with the value 0 (like "0" or "00"), but not by a 0 that is part of an
+// extended color (like "38;5;0" or "48;2;0;0;0").
+func sgrReset(params string) (bool, bool) {
+	if params == "" {
+		return true, true
+	}
+	reset, only := false, true
+	parts := strings.Split(params, ";")
+	for i := 0; i < len(parts); i++ {
+		n, err := strconv.Atoi(parts[i])
+		switch {
+		case err == nil && n == 0:
+			reset = true
+			continue
+		case err == nil && (n == 38 || n == 48 || n == 58) && i+1 < len(parts):
+			// An extended color: "5;<index>" or "2;<r>;<g>;<b>".
+			switch parts[i+1] {
+			case "5":
+				i += 2
+			case "2":
+				i += 4
+			}
+		}
+		only = false
+	}
+	return reset, reset && only
+}
+
+// Strip ANSI escape sequences from the text, and return the SGR (color and text attribute) changes as spans over the
+// stripped text. Other control sequences (e.g. cursor movement, OSC hyperlinks) are dropped.
+func stripANSI(str string) (string, []ansiSpan) {
+	if !strings.Contains(str, "\x1b") {
+		return str, nil
+	}
+
+	var (
+		out   strings.Builder
+		spans []ansiSpan
+		sgr   string
+		runes int
+	)
+	for i := 0; i < len(str); {
+		if str[i] != '\x1b' {
+			r, size := utf8.DecodeRuneInString(str[i:])
+			out.WriteRune(r)
+			runes++
+			i += size
+			continue
+		}
+
+		switch {
+		case i+1 < len(str) && str[i+1] == '[':
+			// CSI sequence: parameters, then a final byte in the range '@' to '~'.
+			j := i + 2
+			for j < len(str) && (str[j] < '@' || str[j] > '~') {
+				j++
+			}
+			if j == len(str) {
+				i = j
+				break
+			}
+			if str[j] == 'm' {
+				params := str[i+2 : j]
+				code := str[i : j+1]
+				// A reset code clears everything before it, so the state starts over. Otherwise, the codes accumulate.
+				if reset, only := sgrReset(params); reset {
+					sgr = code
+					if only {
+						sgr = ""
+					}
+				} else {
+					sgr += code
+				}
+				if len(spans) > 0 && spans[len(spans)-1].start == runes {
+					spans[len(spans)-1].sgr = sgr
+				} else {
+					spans = append(spans, ansiSpan{start: runes, sgr: sgr})
+				}
+			}
+			i = j + 1
+		case i+1 < len(str) && str[i+1] == ']':
+			// OSC sequence: terminated by BEL or by ST ("ESC \").
+			j := i + 2
+			for j < len(str) && str[j] != '\a' && !(str[j] == '\x1b' && j+1 < len(str) && str[j+1] == '\\') {
+				j++
+			}
+			if j < len(str) && str[j] == '\x1b' {
+				j++
+			}
+			i = j + 1
+		default:
+			i += 2
+		}
+	}
+	return out.String(), spans
+}
+
+// Render text with its original ANSI colors and with the matched positions underlined on top. The source maps each
+// rune of the text to its rune offset in the item that the spans describe (see 'fitItem').
+func renderANSI(str string, source []int, matchedPositions []int, spans []ansiSpan) string {
+	matched := make(map[int]bool, len(matchedPositions))
+	for _, p := range matchedPositions {
+		matched[p] = true
+	}
+
+	const reset = "\x1b[0m"
+	const underline = "\x1b[4m"
+	var out strings.Builder
+	prevSGR, prevMatched := "", false
+	for i, r := range []rune(str) {
+		if r == '\n' {
+			out.WriteString(reset + "\n")
+			prevSGR, prevMatched = "", false
+			continue
+		}
+
+		sgr := ""
+		if src := source[i]; src >= 0 {
+			for _, span := range spans {
+				if span.start > src {
+					break
+				}
+				sgr = span.sgr
+			}
+		}
+		if i == 0 || sgr != prevSGR || matched[i] != prevMatched {
+			out.WriteString(reset + sgr)
+			if matched[i] {
+				out.WriteString(underline)
+			}
+		}
+		out.WriteRune(r)
+		prevSGR, prevMatched = sgr, matched[i]
+	}
+	out.WriteString(reset)
+	return out.String()
+}
+
+// Similar to lipgloss.StyleRunes but adapted to work for multi-line text.
+func underlineMatches(str string, matchedPositions []int, style lipgloss.Style) string {
+	underlineStyle := lipgloss.NewStyle().Underline(true).Inherit(style)
+
+	// Convert slice of matched positions to a map for easier lookups
+	m := make(map[int]struct{})
+	for _, i := range matchedPositions {
+		m[i] = struct{}{}
+	}
+
+	noStyle := lipgloss.NewStyle()
+
+	var (
+		out   strings.Builder
+		group strings.Builder
+		runes = []rune(str)
+	)
+
+	for i, r := range runes {
+		if r == '\n' {
+			out.WriteString(noStyle.Render("\n"))
+			continue
+		}
+
+		group.WriteRune(r)
+
+		_, matches := m[i]
+		_, nextMatches := m[i+1]
+
+		if matches != nextMatches || i == len(runes)-1 || runes[i+1] == '\n' {
+			s := group.String()
+			if matches {
+				s = underlineStyle.Render(s)
+			} else {
+				s = style.Render(s)
+			}
+			out.WriteString(s)
+			group.Reset()
+		}
+	}
+
+	return out.String()
+}
@@ -0,0 +1,136 @@
+package finder
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/charmbracelet/bubbles/cursor"
+	tea "github.com/charmbracelet/bubbletea"
+	"io"
+	"strings"
+	"time"
+)
+
+// Script is a scripted session for 'Replay'. Use 'ParseScript' to parse one.
+type Script struct {
+	steps []step
+}
+
+// step is one line of a script. It's either a message for the finder or a wait.
+type step struct {
+	msgs []tea.Msg
+	wait time.Duration
+}
+
+// ParseScript parses a script for 'Replay'. A script has one step per line. Blank lines and lines that start with '#'
+// are ignored. The steps are:
+//   - size <width> <height>: resize the terminal
+//   - type <text>: type the text, one key press per character
+//   - key <name>: press a key, spelled the fzf way (e.g. "down", "ctrl-o" or "alt-e")
+//   - wait <duration>: wait for things that happen in the background, like reloads (e.g. "100ms")
+func ParseScript(r io.Reader) (Script, error) {
+	var script Script
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		command, arg, _ := strings.Cut(line, " ")
+		var s step
+		switch command {
+		case "size":
+			var width, height int
+			if _, err := fmt.Sscanf(arg, "%d %d", &width, &height); err != nil {
+				return Script{}, fmt.Errorf("line %d: expected 'size <width> <height>' but got '%s'", n, line)
+			}
+			s.msgs = []tea.Msg{tea.WindowSizeMsg{Width: width, Height: height}}
+		case "type":
+			for _, r := range arg {
+				s.msgs = append(s.msgs, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+			}
+		case "key":
+			key, ok := parseKey(arg)
+			if !ok {
+				return Script{}, fmt.Errorf("line %d: unknown key '%s'", n, arg)
+			}
+			s.msgs = []tea.Msg{key}
+		case "wait":
+			d, err := time.ParseDuration(arg)
+			if err != nil {
+				return Script{}, fmt.Errorf("line %d: %w", n, err)
+			}
+			s.wait = d
+		default:
+			return Script{}, fmt.Errorf("line %d: unknown step '%s'", n, command)
+		}
+		script.steps = append(script.steps, s)
+	}
+	return script, scanner.Err()
+}
+
+// The Bubble Tea key types by name (e.g. "ctrl+o"). Bubble Tea only maps the other way.
+var keyTypes = func() map[string]tea.KeyType {
+	types := map[string]tea.KeyType{"space": tea.KeySpace}
+	for k := tea.KeyType(-100); k <= tea.KeyBackspace; k++ {
+		if name := (tea.Key{Type: k}).String(); name != "" && k != tea.KeyRunes && k != tea.KeySpace {
+			types[name] = k
+		}
+	}
+	return types
+}()
+
+// Parse a key name spelled the fzf way (e.g. "ctrl-o") or the Bubble Tea way (e.g. "ctrl+o").
+func parseKey(name string) (tea.KeyMsg, bool) {
+	name = teaKeyName(name)
+	alt := false
+	if rest, ok := strings.CutPrefix(name, "alt+"); ok && rest != "" {
+		alt, name = true, rest
+	}
+	if k, ok := keyTypes[name]; ok {
+		return tea.KeyMsg{Type: k, Alt: alt}, true
+	}
+	if runes := []rune(name); len(runes) == 1 {
+		return tea.KeyMsg{Type: tea.KeyRunes, Runes: runes, Alt: alt}, true
+	}
+	return tea.KeyMsg{}, false
+}
+
+// frameMsg asks for the current frame (see 'Replay').
+type frameMsg struct {
+	done chan struct{}
+}
+
+// Replay runs a finder headlessly, without a terminal, and feeds it the steps of a script instead of user input. This
+// is for testing. If the script doesn't accept or abort the finder, then the selection is accepted at the end.
+//
+// If 'frame' is given, it's called with the view of the finder after each step, like it would be rendered.
+func Replay(ctx context.Context, opts Options, script Script, frame func(view string)) (Result, error) {
+	m := New(opts)
+	// A blinking cursor would make the frames differ from run to run.
+	m.input.Cursor.SetMode(cursor.CursorStatic)
+
+	p := tea.NewProgram(program{Model: m, frame: frame}, tea.WithInput(nil), tea.WithOutput(io.Discard), tea.WithoutRenderer(), tea.WithContext(ctx))
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		for _, s := range script.steps {
+			for _, msg := range s.msgs {
+				p.Send(msg)
+			}
+			time.Sleep(s.wait)
+
+			// Wait for the step to be handled, so that the frames are the same from run to run.
+			f := frameMsg{done: make(chan struct{})}
+			p.Send(f)
+			select {
+			case <-f.done:
+			case <-finished:
+				return
+			}
+		}
+		p.Send(tea.KeyMsg{Type: tea.KeyEnter})
+	}()
+	return run(ctx, p, opts, m.s)
+}
@@ -5,16 +5,29 @@ package my_fuzzy_finder
 
 import (
 	"fmt"
+	"my-software/pkg/nuon"
 	"regexp"
 	"slices"
 	"strings"
+	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
 // Pattern represents search pattern
 type Pattern [][]term
 
 func BuildPattern(query string) Pattern {
+	return buildPattern(query, false)
+}
+
+// BuildExactPattern is like BuildPattern, but plain terms are substring matches instead of fuzzy matches. A term that
+// starts with "'" is a fuzzy match instead, like in fzf's '--exact' mode.
+func BuildExactPattern(query string) Pattern {
+	return buildPattern(query, true)
+}
+
+func buildPattern(query string, exact bool) Pattern {
 	query = strings.ToLower(query)
 	runes := []rune(query)
 	asString := strings.TrimLeft(string(runes), " ")
@@ -22,7 +35,7 @@ func BuildPattern(query string) Pattern {
 		asString = asString[:len(asString)-1]
 	}
 
-	return parseTerms(asString)
+	return parseTerms(asString, exact)
 }
 
 func Match(query string, item string) (bool, []int) {
@@ -35,41 +48,294 @@ func Match(query string, item string) (bool, []int) {
 }
 
 func FuzzyMatch(input []rune, pattern []rune) (bool, []int) {
-	var found []int
+	if len(pattern) == 0 {
+		return true, nil
+	}
 
+	// Check for a match first, so that only matching items allocate the positions. Most items don't match.
 	pIdx := 0
-	idx := 0
-	for pIdx < len(pattern) && idx < len(input) {
-		r := input[idx]
-		pr := pattern[pIdx]
-		if r == pr {
-			found = append(found, idx)
+	for idx := 0; pIdx < len(pattern) && idx < len(input); idx++ {
+		if input[idx] == pattern[pIdx] {
 			pIdx++
 		}
-		idx++
 	}
-
 	if pIdx != len(pattern) {
 		return false, nil
 	}
 
+	found := make([]int, 0, len(pattern))
+	pIdx = 0
+	for idx := 0; pIdx < len(pattern); idx++ {
+		if input[idx] == pattern[pIdx] {
+			found = append(found, idx)
+			pIdx++
+		}
+	}
 	return true, found
 }
 
+// ApproximateMatch finds the substring of the input that is closest to the pattern in edit distance, where an edit is
+// an insertion, a deletion, a substitution or a transposition of two adjacent characters. It matches if the distance is
+// at most maxEdits. The positions are those of the input characters that line up with pattern characters, and the
+// number of edits is returned too.
+//
+// This is the optimal string alignment distance, computed over all substrings at once (Sellers' algorithm). For
+// example, "cnofig" matches "my-config" with one edit.
+func ApproximateMatch(input []rune, pattern []rune, maxEdits int) (bool, []int, int) {
+	rows, cols := len(pattern)+1, len(input)+1
+
+	// Most items don't match, so first find the distance with rolling rows: the row before the previous one (for
+	// transpositions), the previous one and the current one. The smallest value in a row never gets smaller in the rows
+	// below it, so the distance is too big as soon as a row's smallest value is.
+	before, prev, cur := make([]int, cols), make([]int, cols), make([]int, cols)
+	for i := 1; i < rows; i++ {
+		cur[0] = i
+		least := i
+		for j := 1; j < cols; j++ {
+			cost := 1
+			if pattern[i-1] == input[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && pattern[i-1] == input[j-2] && pattern[i-2] == input[j-1] {
+				cur[j] = min(cur[j], before[j-2]+1)
+			}
+			least = min(least, cur[j])
+		}
+		if least > maxEdits {
+			return false, nil, 0
+		}
+		before, prev, cur = prev, cur, before
+	}
+
+	end := 0
+	for j := 1; j < cols; j++ {
+		if prev[j] < prev[end] {
+			end = j
+		}
+	}
+	edits := prev[end]
+	if edits > maxEdits {
+		return false, nil, 0
+	}
+
+	// The item matches, so compute the whole matrix up to the end of the best substring, for the positions.
+	cols = end + 1
+	d := make([][]int, rows)
+	for i := range d {
+		d[i] = make([]int, cols)
+		d[i][0] = i
+	}
+	for i := 1; i < rows; i++ {
+		for j := 1; j < cols; j++ {
+			cost := 1
+			if pattern[i-1] == input[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && pattern[i-1] == input[j-2] && pattern[i-2] == input[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	// Trace the alignment back from the end of the best substring to collect the positions.
+	var positions []int
+	i, j := rows-1, end
+	for i > 0 && j > 0 {
+		switch {
+		case pattern[i-1] == input[j-1] && d[i][j] == d[i-1][j-1]:
+			positions = append(positions, j-1)
+			i, j = i-1, j-1
+		case i > 1 && j > 1 && pattern[i-1] == input[j-2] && pattern[i-2] == input[j-1] && d[i][j] == d[i-2][j-2]+1:
+			positions = append(positions, j-1, j-2)
+			i, j = i-2, j-2
+		case d[i][j] == d[i-1][j-1]+1:
+			i, j = i-1, j-1
+		case d[i][j] == d[i-1][j]+1:
+			i--
+		default:
+			j--
+		}
+	}
+	slices.Sort(positions)
+	return true, positions, edits
+}
+
+// maxEdits is how many typos a term of the given length can have in approximate matching. Short terms would match
+// nearly anything with a typo, so they get none.
+func maxEdits(pattern []rune) int {
+	switch {
+	case len(pattern) < 3:
+		return 0
+	case len(pattern) < 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// WordMatch finds the pattern in the input as a whole word, between delimiters. The case of the input is ignored, and
+// the pattern is expected to be lowercase, like the terms of a pattern.
 func WordMatch(input string, pattern string) (bool, []int) {
-	m := strings.Index(input, pattern)
+	return wordMatch(input, []rune(pattern))
+}
+
+func wordMatch(input string, pattern []rune) (bool, []int) {
+	m, from, to := indexFold(input, pattern)
 	if m == -1 {
 		return false, nil
 	}
 
-	inputRunes := []rune(input)
+	before, _ := utf8.DecodeLastRuneInString(input[:from])
+	after, _ := utf8.DecodeRuneInString(input[to:])
+	if (from > 0 && !isDelimiter(before)) || (to < len(input) && !isDelimiter(after)) {
+		return false, nil
+	}
+
+	return true, offsetsToPositions(m, m+len(pattern))
+}
+
+// lower lowercases a rune, quickly for ASCII.
+func lower(r rune) rune {
+	if r < utf8.RuneSelf {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}
+	return unicode.ToLower(r)
+}
 
-	end := m + len(pattern)
-	if (m > 0 && !isDelimiter(inputRunes[m])) || (end < len(input) && !isDelimiter(inputRunes[end])) {
+// fuzzyMatch is 'FuzzyMatch' on an item as it is, ignoring its case. The pattern is lowercase.
+func fuzzyMatch(input string, pattern []rune) (bool, []int) {
+	if len(pattern) == 0 {
+		return true, nil
+	}
+
+	// Check for a match first, so that only matching items allocate the positions. Most items don't match.
+	pIdx := 0
+	for _, r := range input {
+		if lower(r) == pattern[pIdx] {
+			if pIdx++; pIdx == len(pattern) {
+				break
+			}
+		}
+	}
+	if pIdx != len(pattern) {
 		return false, nil
 	}
 
-	return true, offsetsToPositions(m, end)
+	found := make([]int, 0, len(pattern))
+	pIdx, idx := 0, 0
+	for _, r := range input {
+		if lower(r) == pattern[pIdx] {
+			found = append(found, idx)
+			if pIdx++; pIdx == len(pattern) {
+				break
+			}
+		}
+		idx++
+	}
+	return true, found
+}
+
+// prefixFold tells whether the input starts with the pattern, ignoring the case of the input. It returns the length of
+// the prefix in bytes.
+func prefixFold(input string, pattern []rune) (int, bool) {
+	i := 0
+	for _, p := range pattern {
+		if i == len(input) {
+			return 0, false
+		}
+		r, size := utf8.DecodeRuneInString(input[i:])
+		if lower(r) != p {
+			return 0, false
+		}
+		i += size
+	}
+	return i, true
+}
+
+// suffixFold tells whether the input ends with the pattern, ignoring the case of the input. It returns where the
+// suffix starts in bytes.
+func suffixFold(input string, pattern []rune) (int, bool) {
+	i := len(input)
+	for k := len(pattern) - 1; k >= 0; k-- {
+		if i == 0 {
+			return 0, false
+		}
+		r, size := utf8.DecodeLastRuneInString(input[:i])
+		if lower(r) != pattern[k] {
+			return 0, false
+		}
+		i -= size
+	}
+	return i, true
+}
+
+// indexFold is 'strings.Index' that ignores the case of the input. It returns the rune offset of the first occurrence
+// of the pattern (or -1), and where it is in bytes.
+func indexFold(input string, pattern []rune) (int, int, int) {
+	if len(pattern) == 0 {
+		return 0, 0, 0
+	}
+	m := 0
+	for i, r := range input {
+		if lower(r) == pattern[0] {
+			if n, ok := prefixFold(input[i:], pattern); ok {
+				return m, i, i + n
+			}
+		}
+		m++
+	}
+	return -1, 0, 0
+}
+
+const (
+	scoreMatch        = 16
+	bonusBoundary     = 8
+	bonusConsecutive  = 4
+	penaltyGapPerRune = 1
+)
+
+// Score rates how well an item matched, given the matched positions (rune offsets into the item) from
+// Pattern.MatchItem. A higher score is better.
+//
+// This is a rough take on the fzf scoring scheme (https://github.com/junegunn/fzf/blob/master/src/algo/algo.go). Each
+// matched character scores points, with bonuses for characters at the start of a word and for runs of consecutive
+// characters. Gaps between matched characters are penalized.
+func Score(input []rune, positions []int) int {
+	return score(len(input), positions, func(pos int) bool {
+		return isDelimiter(input[pos-1]) || (unicode.IsLower(input[pos-1]) && unicode.IsUpper(input[pos]))
+	})
+}
+
+// score implements 'Score' for an input of length n. The 'boundary' function tells whether the character at a position
+// (never the first) starts a word.
+func score(n int, positions []int, boundary func(pos int) bool) int {
+	score := 0
+	prev := -1
+	for _, pos := range positions {
+		if pos <= prev || pos >= n {
+			// Positions can repeat when multiple terms match the same characters.
+			continue
+		}
+
+		score += scoreMatch
+		if pos == 0 || boundary(pos) {
+			score += bonusBoundary
+		}
+		if prev >= 0 {
+			if pos == prev+1 {
+				score += bonusConsecutive
+			} else {
+				score -= penaltyGapPerRune * (pos - prev - 1)
+			}
+		}
+		prev = pos
+	}
+	return score
 }
 
 func isDelimiter(char rune) bool {
@@ -85,19 +351,41 @@ const (
 	termPrefix
 	termSuffix
 	termSame
+	termCompare
 )
 
 type term struct {
 	typ  termType
 	inv  bool
 	text string
+
+	// The runes of 'text' and 'raw', which are what's matched against.
+	runes    []rune
+	rawRunes []rune
+
+	// For a field-qualified term like 'name:readme', the name of the field. When the item isn't a record, the term
+	// matches the raw token text instead.
+	field string
+	raw   string
+
+	// For a comparison term like 'size:>1mb', the operator ('>', '>=', '<', '<=' or '=').
+	op string
+
+	// Whether a fuzzy term also matches with a few typos (see 'Pattern.Approximate').
+	approximate bool
+
+	// Whether the '^' and '$' anchors apply to each line instead of the whole item (see 'Pattern.PerLine').
+	perLine bool
 }
 
 // String returns the string representation of a term.
 func (t term) String() string {
-	return fmt.Sprintf("term{typ: %d, inv: %v, text: []rune(%q)}", t.typ, t.inv, t.text)
+	return fmt.Sprintf("term{typ: %d, inv: %v, text: []rune(%q), field: %q, op: %q}", t.typ, t.inv, t.text, t.field, t.op)
 }
 
+var fieldQualifier = regexp.MustCompile(`^([a-z_][a-z0-9_-]*):(.+)$`)
+var comparison = regexp.MustCompile(`^(>=|<=|>|<|=)(.+)$`)
+
 // Parse term sets from the query
 //
 // For example, given the query:
@@ -128,7 +416,11 @@ func (t term) String() string {
 // >        - Term 2: Match "yyy"
 // >        - Term 3: Suffix match "zzz"
 // >        - Term 4: Inverted match "ZZZ"
-func parseTerms(query string) [][]term {
+//
+// A term can be qualified with a field name, like 'name:readme' or '!type:^dir', to match only that field of a record
+// item. A qualified term can instead be a comparison against a numeric or date field, like 'size:>1mb',
+// 'modified:<3day' (less than 3 days ago) or 'modified:>=2024-01-31'. See 'compare'.
+func parseTerms(query string, exact bool) [][]term {
 	query = strings.ReplaceAll(query, "\\ ", "\t")
 	tokens := regexp.MustCompile(" +").Split(query, -1)
 	var termSets [][]term
@@ -137,6 +429,9 @@ func parseTerms(query string) [][]term {
 	afterBar := false
 	for _, token := range tokens {
 		typ, inv, text := termFuzzy, false, strings.ReplaceAll(token, "\t", " ")
+		if exact {
+			typ = termContains
+		}
 
 		if len(termSet) > 0 && !afterBar && text == "|" {
 			switchSet = false
@@ -151,29 +446,40 @@ func parseTerms(query string) [][]term {
 			text = text[1:]
 		}
 
-		if text != "$" && strings.HasSuffix(text, "$") {
-			typ = termSuffix
-			text = text[:len(text)-1]
+		var field, raw, op string
+		if groups := fieldQualifier.FindStringSubmatch(text); groups != nil {
+			field, raw, text = groups[1], text, groups[2]
+			if groups := comparison.FindStringSubmatch(text); groups != nil {
+				// The comparison value is taken as-is. There are no prefixes or suffixes to strip.
+				typ, op, text = termCompare, groups[1], groups[2]
+			}
 		}
 
-		if len(text) > 2 && strings.HasPrefix(text, "'") && strings.HasSuffix(text, "'") {
-			typ = termWord
-			text = text[1 : len(text)-1]
-		} else if strings.HasPrefix(text, "'") {
-			// Flip exactness
-			if !inv {
-				typ = termContains
-			} else {
-				typ = termFuzzy
+		if typ != termCompare {
+			if text != "$" && strings.HasSuffix(text, "$") {
+				typ = termSuffix
+				text = text[:len(text)-1]
 			}
-			text = text[1:]
-		} else if strings.HasPrefix(text, "^") {
-			if typ == termSuffix {
-				typ = termSame
-			} else {
-				typ = termPrefix
+
+			if len(text) > 2 && strings.HasPrefix(text, "'") && strings.HasSuffix(text, "'") {
+				typ = termWord
+				text = text[1 : len(text)-1]
+			} else if strings.HasPrefix(text, "'") {
+				// Flip exactness
+				if !exact && !inv {
+					typ = termContains
+				} else {
+					typ = termFuzzy
+				}
+				text = text[1:]
+			} else if strings.HasPrefix(text, "^") {
+				if typ == termSuffix {
+					typ = termSame
+				} else {
+					typ = termPrefix
+				}
+				text = text[1:]
 			}
-			text = text[1:]
 		}
 
 		if len(text) > 0 {
@@ -182,9 +488,14 @@ func parseTerms(query string) [][]term {
 				termSet = []term{}
 			}
 			termSet = append(termSet, term{
-				typ:  typ,
-				inv:  inv,
-				text: text})
+				typ:      typ,
+				inv:      inv,
+				text:     text,
+				runes:    []rune(text),
+				field:    field,
+				raw:      raw,
+				rawRunes: []rune(raw),
+				op:       op})
 			switchSet = true
 		}
 	}
@@ -194,67 +505,251 @@ func parseTerms(query string) [][]term {
 	return termSets
 }
 
+// Approximate returns a copy of the pattern where the fuzzy terms also match with a few typos, like "cnofig" for
+// "config". See 'ApproximateMatch'.
+func (p Pattern) Approximate() Pattern {
+	approximate := make(Pattern, len(p))
+	for i, termSet := range p {
+		approximate[i] = slices.Clone(termSet)
+		for j := range approximate[i] {
+			approximate[i][j].approximate = true
+		}
+	}
+	return approximate
+}
+
+// PerLine returns a copy of the pattern where the '^' and '$' anchors apply to each line of a multi-line item, instead
+// of to the start and end of the whole item. All matching lines are highlighted.
+func (p Pattern) PerLine() Pattern {
+	perLine := make(Pattern, len(p))
+	for i, termSet := range p {
+		perLine[i] = slices.Clone(termSet)
+		for j := range perLine[i] {
+			perLine[i][j].perLine = true
+		}
+	}
+	return perLine
+}
+
 func (p Pattern) MatchItem(input string) (bool, []int) {
-	input = strings.ToLower(input)
-	var allPos []int
+	ok, m := p.MatchRecord(input, nil)
+	return ok, m.Positions
+}
+
+// Field is a named field of a record item.
+type Field struct {
+	Name string
+	Text string
+}
+
+// RecordMatch describes how an item matched.
+type RecordMatch struct {
+	// The positions of the highlights in the text and in each of the fields.
+	Positions      []int
+	FieldPositions [][]int
+
+	// The number of typos that were allowed for in approximate matching.
+	Edits int
+}
+
+// MatchRecord matches a record item. Unqualified terms match the text (e.g. the displayed form of the record), and
+// field-qualified terms match the field with that name. The positions of the highlights are reported for the text and
+// for each of the fields.
+//
+// When fields is nil, the item isn't a record and field-qualified terms match the text as if they weren't qualified.
+//
+// The item is matched as it is, ignoring its case as it goes, so matching allocates nothing unless the item matches.
+func (p Pattern) MatchRecord(text string, fields []Field) (bool, RecordMatch) {
+	return p.matchRecord(text, fields != nil, fields)
+}
+
+func (p Pattern) matchRecord(text string, record bool, fields []Field) (bool, RecordMatch) {
+	var m RecordMatch
 	for _, termSet := range p {
-		ok, pos := match(termSet, input)
+		ok, pos, field, edits := match(termSet, text, record, fields)
 		if !ok {
-			return false, nil
+			return false, RecordMatch{}
+		}
+		m.Edits += edits
+		if field == -1 {
+			m.Positions = append(m.Positions, pos...)
+			continue
+		}
+		if len(pos) == 0 {
+			continue
+		}
+		if m.FieldPositions == nil {
+			m.FieldPositions = make([][]int, len(fields))
 		}
-		allPos = append(allPos, pos...)
+		m.FieldPositions[field] = append(m.FieldPositions[field], pos...)
 	}
 
-	slices.Sort(allPos)
-	return true, allPos
+	slices.Sort(m.Positions)
+	for _, pos := range m.FieldPositions {
+		slices.Sort(pos)
+	}
+	return true, m
 }
 
-func match(termSet []term, input string) (bool, []int) {
-	var allPos []int
-	setMatched := false
+// match matches a term set against an item and its fields. It returns the positions of the matching term, which field
+// they are in (or -1 for the text) and the number of typos that were allowed for.
+func match(termSet []term, text string, record bool, fields []Field) (bool, []int, int, int) {
 	for _, term := range termSet {
+		input, field := text, -1
+		if term.field != "" && !record {
+			term = unqualified(term)
+		} else if term.field != "" {
+			field = slices.IndexFunc(fields, func(f Field) bool { return strings.EqualFold(f.Name, term.field) })
+			if field == -1 {
+				// A missing field matches nothing.
+				if term.inv {
+					return true, nil, -1, 0
+				}
+				continue
+			}
+			input = fields[field].Text
+		}
+
+		if term.perLine && strings.Contains(input, "\n") && slices.Contains([]termType{termPrefix, termSuffix, termSame}, term.typ) {
+			if ok, pos := matchLines(term, input); ok != term.inv {
+				return true, pos, field, 0
+			}
+			continue
+		}
+
 		var matched bool
 		var pos []int
+		edits := 0
 
 		switch term.typ {
 		case termFuzzy:
-			matched, pos = FuzzyMatch([]rune(input), []rune(term.text))
+			matched, pos = fuzzyMatch(input, term.runes)
+			if !matched && term.approximate && !term.inv {
+				// Typos are rare enough that lowercasing the input into runes is fine.
+				runes := []rune(input)
+				for i, r := range runes {
+					runes[i] = lower(r)
+				}
+				matched, pos, edits = ApproximateMatch(runes, term.runes, maxEdits(term.runes))
+			}
 		case termSame:
-			if input == term.text {
+			if n, ok := prefixFold(input, term.runes); ok && n == len(input) {
 				matched = true
-				pos = offsetsToPositions(0, len(input))
+				pos = offsetsToPositions(0, len(term.runes))
 			}
 		case termContains:
-			start := strings.Index(input, term.text)
-			if start >= 0 {
+			if start, _, _ := indexFold(input, term.runes); start >= 0 {
 				matched = true
-				pos = offsetsToPositions(start, start+len(term.text))
+				pos = offsetsToPositions(start, start+len(term.runes))
 			}
 		case termWord:
-			matched, pos = WordMatch(input, term.text)
+			matched, pos = wordMatch(input, term.runes)
 		case termPrefix:
-			if strings.HasPrefix(input, term.text) {
+			if _, ok := prefixFold(input, term.runes); ok {
 				matched = true
-				pos = offsetsToPositions(0, len(term.text))
+				pos = offsetsToPositions(0, len(term.runes))
 			}
 		case termSuffix:
-			if strings.HasSuffix(input, term.text) {
+			if i, ok := suffixFold(input, term.runes); ok {
 				matched = true
-				start := len(input) - len(term.text)
-				pos = offsetsToPositions(start, len(input))
+				start := utf8.RuneCountInString(input[:i])
+				pos = offsetsToPositions(start, start+len(term.runes))
+			}
+		case termCompare:
+			if compare(strings.ToLower(input), term.op, term.text) {
+				matched = true
+				pos = offsetsToPositions(0, utf8.RuneCountInString(input))
 			}
 		default:
 			panic("Unknown term type: " + term.String())
 		}
 
 		if (matched && !term.inv) || (!matched && term.inv) {
-			setMatched = true
-			allPos = append(allPos, pos...)
+			return true, pos, field, edits
+		}
+	}
+
+	return false, nil, -1, 0
+}
+
+// matchLines matches an anchored term against each line of the input. The positions of all matching lines are
+// returned.
+func matchLines(t term, input string) (bool, []int) {
+	t.inv, t.field, t.perLine = false, "", false
+	var allPos []int
+	lineStart := 0
+	for {
+		line, rest, more := strings.Cut(input, "\n")
+		if ok, pos, _, _ := match([]term{t}, line, false, nil); ok {
+			for _, p := range pos {
+				allPos = append(allPos, lineStart+p)
+			}
+		}
+		if !more {
 			break
 		}
+		lineStart += utf8.RuneCountInString(line) + 1
+		input = rest
 	}
+	return allPos != nil, allPos
+}
+
+// unqualified turns a field-qualified term back into a plain term on its raw token text, for items that aren't records.
+func unqualified(t term) term {
+	typ := t.typ
+	if typ != termFuzzy && typ != termContains {
+		typ = termFuzzy
+		if t.inv {
+			typ = termContains
+		}
+	}
+	return term{typ: typ, inv: t.inv, text: t.raw, runes: t.rawRunes, approximate: t.approximate, perLine: t.perLine}
+}
 
-	return setMatched, allPos
+// compare compares a field's text against a value from a query.
+//
+// Quantities compare in their base units, like '1.5kib' against '1mb'. File sizes and durations don't compare with each
+// other, but a plain number compares with either (e.g. a size in bytes from 'to json'). A date compares against another
+// date, or its age compares against a duration (e.g. a date 1 hour ago is '<3day'). Anything else doesn't compare.
+func compare(a, op, b string) bool {
+	a = strings.TrimSpace(a)
+	var x, y float64
+	if date, ok := nuon.ParseDate(a); ok {
+		if other, ok := nuon.ParseDate(b); ok {
+			x, y = float64(date.UnixNano()), float64(other.UnixNano())
+		} else if kind, d, ok := nuon.ParseQuantity(b); ok && kind == nuon.Duration {
+			x, y = float64(time.Since(date)), d
+		} else {
+			return false
+		}
+	} else {
+		xKind, xValue, ok := nuon.ParseQuantity(a)
+		if !ok {
+			return false
+		}
+		yKind, yValue, ok := nuon.ParseQuantity(b)
+		if !ok {
+			return false
+		}
+		number := func(k nuon.Kind) bool { return k == nuon.Int || k == nuon.Float }
+		if xKind != yKind && !number(xKind) && !number(yKind) {
+			return false
+		}
+		x, y = xValue, yValue
+	}
+
+	switch op {
+	case ">":
+		return x > y
+	case ">=":
+		return x >= y
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	default:
+		return x == y
+	}
 }
 
 func offsetsToPositions(start, end int) []int {
@@ -3,6 +3,7 @@ package my_fuzzy_finder
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestMatchOne(t *testing.T) {
@@ -96,6 +97,18 @@ func TestMatchOne(t *testing.T) {
 			expectedMatch: true,
 			expectedPos:   []int{0, 1, 2},
 		},
+		"Exact match with quotes after a delimiter": {
+			query:         "'abc'",
+			item:          "xyz abc",
+			expectedMatch: true,
+			expectedPos:   []int{4, 5, 6},
+		},
+		"Exact match positions are rune offsets": {
+			query:         "'öl",
+			item:          "Köln",
+			expectedMatch: true,
+			expectedPos:   []int{1, 2},
+		},
 		"Prefix match": {
 			query:         "^ab",
 			item:          "abc",
@@ -170,3 +183,348 @@ func TestMatchOne(t *testing.T) {
 		})
 	}
 }
+
+func TestScore(t *testing.T) {
+	tests := map[string]struct {
+		query  string
+		better string
+		worse  string
+	}{
+		"Consecutive beats spread out": {
+			query:  "abc",
+			better: "xabcx",
+			worse:  "xaxbxcx",
+		},
+		"Word start beats middle of word": {
+			query:  "bar",
+			better: "foo/bar",
+			worse:  "foobarx",
+		},
+		"Camel case hump is a word start": {
+			query:  "bar",
+			better: "fooBar",
+			worse:  "foobar",
+		},
+		"Smaller gaps beat bigger gaps": {
+			query:  "ab",
+			better: "a_b",
+			worse:  "a____b",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, betterPos := Match(tt.query, tt.better)
+			_, worsePos := Match(tt.query, tt.worse)
+			betterScore := Score([]rune(tt.better), betterPos)
+			worseScore := Score([]rune(tt.worse), worsePos)
+			if betterScore <= worseScore {
+				t.Errorf("Score(%q) = %d, want more than Score(%q) = %d", tt.better, betterScore, tt.worse, worseScore)
+			}
+		})
+	}
+}
+
+func TestMatchRecord(t *testing.T) {
+	record := []Field{
+		{Name: "name", Text: "README.md"},
+		{Name: "type", Text: "file"},
+		{Name: "size", Text: "1.2KiB"},
+	}
+
+	tests := map[string]struct {
+		query             string
+		expectedMatch     bool
+		expectedPos       []int
+		expectedFieldsPos [][]int
+	}{
+		"Field term": {
+			query:             "name:read",
+			expectedMatch:     true,
+			expectedFieldsPos: [][]int{{0, 1, 2, 3}, nil, nil},
+		},
+		"Field term on another field": {
+			query:         "type:read",
+			expectedMatch: false,
+		},
+		"Inverted field term": {
+			query:             "name:read !type:dir",
+			expectedMatch:     true,
+			expectedFieldsPos: [][]int{{0, 1, 2, 3}, nil, nil},
+		},
+		"Prefix field term": {
+			query:             "type:^fi",
+			expectedMatch:     true,
+			expectedFieldsPos: [][]int{nil, {0, 1}, nil},
+		},
+		"Unqualified term matches the text": {
+			query:         "rdm",
+			expectedMatch: true,
+			expectedPos:   []int{0, 3, 4},
+		},
+		"Missing field": {
+			query:         "owner:me",
+			expectedMatch: false,
+		},
+		"Inverted missing field": {
+			query:         "!owner:me",
+			expectedMatch: true,
+		},
+		"Greater than": {
+			query:             "size:>1kb",
+			expectedMatch:     true,
+			expectedFieldsPos: [][]int{nil, nil, {0, 1, 2, 3, 4, 5}},
+		},
+		"Less than": {
+			query:         "size:<1kb",
+			expectedMatch: false,
+		},
+		"Inverted comparison": {
+			query:         "!size:>=1mb",
+			expectedMatch: true,
+		},
+		"Not a quantity": {
+			query:         "type:>1kb",
+			expectedMatch: false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			matched, m := BuildPattern(tt.query).MatchRecord("README.md file", record)
+			positions, fieldsPos := m.Positions, m.FieldPositions
+			if matched != tt.expectedMatch {
+				t.Errorf("MatchRecord() matched = %v, want %v", matched, tt.expectedMatch)
+			}
+			if !matched {
+				return
+			}
+			if !reflect.DeepEqual(positions, tt.expectedPos) {
+				t.Errorf("MatchRecord() positions = %v, want %v", positions, tt.expectedPos)
+			}
+			if !reflect.DeepEqual(fieldsPos, tt.expectedFieldsPos) {
+				t.Errorf("MatchRecord() field positions = %v, want %v", fieldsPos, tt.expectedFieldsPos)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	hourAgo := time.Now().Add(-time.Hour).Format(time.RFC3339)
+
+	tests := map[string]struct {
+		a, op, b string
+		expected bool
+	}{
+		"File sizes":                  {"1.2kib", ">", "1kb", true},
+		"File size and duration":      {"1.2kib", ">", "1day", false},
+		"Duration and file size":      {"2day", ">", "1kb", false},
+		"Number and file size":        {"1200", ">", "1kb", true},
+		"Recent date by age":          {hourAgo, "<", "3day", true},
+		"Recent date by age, too old": {hourAgo, ">", "3day", false},
+		"Old date by age":             {"2020-01-31", "<", "3day", false},
+		"Dates":                       {hourAgo, ">", "2020-01-31", true},
+		"Date and time":               {"2020-01-31 12:00", "<", "2020-01-31t13:00", true},
+		"Date and file size":          {"2020-01-31", ">", "1kb", false},
+		"Not a quantity":              {"file", ">", "1kb", false},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := compare(tt.a, tt.op, tt.b); got != tt.expected {
+				t.Errorf("compare(%q, %q, %q) = %v, want %v", tt.a, tt.op, tt.b, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFieldTermsOnPlainItems(t *testing.T) {
+	matched, positions := Match("http:x", "http://x")
+	if !matched || !reflect.DeepEqual(positions, []int{0, 1, 2, 3, 4, 7}) {
+		t.Errorf("Match() = %v, %v, want a fuzzy match on the whole term", matched, positions)
+	}
+}
+
+func TestApproximateMatch(t *testing.T) {
+	tests := map[string]struct {
+		query         string
+		item          string
+		expectedMatch bool
+		expectedPos   []int
+		expectedEdits int
+	}{
+		"Exact subsequence needs no edits": {
+			query:         "cfg",
+			item:          "config",
+			expectedMatch: true,
+			expectedPos:   []int{0, 3, 5},
+		},
+		"Transposition": {
+			query:         "cnofig",
+			item:          "my-config.yaml",
+			expectedMatch: true,
+			expectedPos:   []int{3, 4, 5, 6, 7, 8},
+			expectedEdits: 1,
+		},
+		"Substitution": {
+			query:         "cinfig",
+			item:          "config",
+			expectedMatch: true,
+			expectedPos:   []int{0, 2, 3, 4, 5},
+			expectedEdits: 1,
+		},
+		"Deletion": {
+			query:         "conffig",
+			item:          "config",
+			expectedMatch: true,
+			expectedPos:   []int{0, 1, 2, 3, 4, 5},
+			expectedEdits: 1,
+		},
+		"Two edits": {
+			query:         "cnofgi",
+			item:          "config",
+			expectedMatch: true,
+			expectedPos:   []int{0, 1, 2, 3, 4},
+			expectedEdits: 2,
+		},
+		"Too many edits": {
+			query:         "gifnoc",
+			item:          "config",
+			expectedMatch: false,
+		},
+		"Short terms get no edits": {
+			query:         "xb",
+			item:          "ab",
+			expectedMatch: false,
+		},
+		"Inverted terms are exact": {
+			query:         "!cnofig",
+			item:          "config",
+			expectedMatch: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			matched, m := BuildPattern(tt.query).Approximate().MatchRecord(tt.item, nil)
+			if matched != tt.expectedMatch {
+				t.Fatalf("MatchRecord() matched = %v, want %v", matched, tt.expectedMatch)
+			}
+			if !reflect.DeepEqual(m.Positions, tt.expectedPos) {
+				t.Errorf("MatchRecord() positions = %v, want %v", m.Positions, tt.expectedPos)
+			}
+			if m.Edits != tt.expectedEdits {
+				t.Errorf("MatchRecord() edits = %d, want %d", m.Edits, tt.expectedEdits)
+			}
+		})
+	}
+
+	// A term that is a subsequence matches fuzzily before it's matched approximately, so this one is only an insertion
+	// when it's matched approximately directly.
+	if matched, positions, edits := ApproximateMatch([]rune("config"), []rune("cofig"), 1); !matched || edits != 1 || !reflect.DeepEqual(positions, []int{0, 1, 3, 4, 5}) {
+		t.Errorf("ApproximateMatch() = %v, %v, %d, want an insertion", matched, positions, edits)
+	}
+
+	if matched, _ := Match("cnofig", "config"); matched {
+		t.Errorf("Match() matched a typo without approximate matching")
+	}
+}
+
+func TestPerLine(t *testing.T) {
+	item := "Dear reader,\nHello.\nSincerely, writer"
+	tests := map[string]struct {
+		query         string
+		expectedMatch bool
+		expectedPos   []int
+	}{
+		"Prefix of a line": {
+			query:         "^hello",
+			expectedMatch: true,
+			expectedPos:   []int{13, 14, 15, 16, 17},
+		},
+		"Suffix of a line": {
+			query:         "reader,$",
+			expectedMatch: true,
+			expectedPos:   []int{5, 6, 7, 8, 9, 10, 11},
+		},
+		"Whole line": {
+			query:         "^hello.$",
+			expectedMatch: true,
+			expectedPos:   []int{13, 14, 15, 16, 17, 18},
+		},
+		"Inverted": {
+			query:         "!^hello",
+			expectedMatch: false,
+		},
+		"No line matches": {
+			query:         "^reader",
+			expectedMatch: false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			matched, positions := BuildPattern(tt.query).PerLine().MatchItem(item)
+			if matched != tt.expectedMatch {
+				t.Fatalf("MatchItem() matched = %v, want %v", matched, tt.expectedMatch)
+			}
+			if !reflect.DeepEqual(positions, tt.expectedPos) {
+				t.Errorf("MatchItem() positions = %v, want %v", positions, tt.expectedPos)
+			}
+		})
+	}
+
+	if matched, _ := Match("^hello", item); matched {
+		t.Errorf("Match() matched a line anchor without per-line matching")
+	}
+}
+
+func TestBuildExactPattern(t *testing.T) {
+	tests := map[string]struct {
+		query         string
+		item          string
+		expectedMatch bool
+		expectedPos   []int
+	}{
+		"Terms are substrings": {
+			query:         "abc",
+			item:          "xabcx",
+			expectedMatch: true,
+			expectedPos:   []int{1, 2, 3},
+		},
+		"Terms are not fuzzy": {
+			query:         "abc",
+			item:          "a_b_c",
+			expectedMatch: false,
+		},
+		"Quoted terms are fuzzy": {
+			query:         "'abc",
+			item:          "a_b_c",
+			expectedMatch: true,
+			expectedPos:   []int{0, 2, 4},
+		},
+		"Anchors still work": {
+			query:         "^ab c$",
+			item:          "abc",
+			expectedMatch: true,
+			expectedPos:   []int{0, 1, 2},
+		},
+		"Inverted terms": {
+			query:         "!abc",
+			item:          "a_b_c",
+			expectedMatch: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			matched, positions := BuildExactPattern(tt.query).MatchItem(tt.item)
+			if matched != tt.expectedMatch {
+				t.Fatalf("MatchItem() matched = %v, want %v", matched, tt.expectedMatch)
+			}
+			if !reflect.DeepEqual(positions, tt.expectedPos) {
+				t.Errorf("MatchItem() positions = %v, want %v", positions, tt.expectedPos)
+			}
+		})
+	}
+}
@@ -0,0 +1,369 @@
+package my_fuzzy_finder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+	"sort"
+	"unicode"
+)
+
+// Index is an n-gram index of items, for corpora that are too big to match item by item on every keystroke, like every
+// file path on a machine. It finds the candidates for a pattern (see 'Candidates'), which are then matched as usual.
+// Build an index with an 'IndexBuilder' and read it with 'ReadIndex'.
+//
+// The index file saves building the index on every run, but a read index is all in memory. It takes about as much
+// memory as the file.
+//
+// The grams are the trigrams of the lowercased items, for substring terms like "'readme" or "^src", and the single
+// characters, for fuzzy terms. A fuzzy term can match any item that has all of its characters, so the index narrows
+// fuzzy terms down less than substring terms.
+//
+// The file format is:
+//   - the magic "MFFIDX1\n"
+//   - the number of items, then each item as its length and its bytes
+//   - the number of grams, then a table of the grams in ascending order. Each entry is the gram, the offset of its
+//     posting list and the number of items in the list.
+//   - the posting lists. A posting list is the ascending item numbers, each stored as the difference from the previous
+//     one.
+//
+// All numbers are little-endian uint32s, except for the grams and the offsets, which are uint64s, and the numbers in
+// the posting lists, which are varints.
+type Index struct {
+	items    []string
+	table    []byte
+	postings []byte
+}
+
+const indexMagic = "MFFIDX1\n"
+
+// The size of a gram table entry: the gram, the offset and the count.
+const indexEntrySize = 8 + 8 + 4
+
+// The grams are keyed by their runes. A rune has 21 bits, so a trigram fits in a uint64. Single characters have the
+// top bit set, so that they don't collide with trigrams.
+const unigramBit = 1 << 63
+
+func trigram(a, b, c rune) uint64 {
+	return uint64(a)<<42 | uint64(b)<<21 | uint64(c)
+}
+
+func unigram(r rune) uint64 {
+	return unigramBit | uint64(r)
+}
+
+// IndexBuilder builds an 'Index'. The zero value is an empty builder.
+type IndexBuilder struct {
+	items []string
+	grams map[uint64]*posting
+}
+
+// posting is the posting list of a gram while it's being built.
+type posting struct {
+	last  uint32
+	count uint32
+	data  []byte
+}
+
+// Add adds an item to the index.
+func (b *IndexBuilder) Add(item string) {
+	if b.grams == nil {
+		b.grams = make(map[uint64]*posting)
+	}
+	n := uint32(len(b.items))
+	b.items = append(b.items, item)
+
+	add := func(gram uint64) {
+		p, ok := b.grams[gram]
+		if !ok {
+			p = &posting{}
+			b.grams[gram] = p
+		} else if p.last == n && p.count > 0 {
+			// The gram occurs more than once in the item.
+			return
+		}
+		p.data = binary.AppendUvarint(p.data, uint64(n-p.last))
+		p.last = n
+		p.count++
+	}
+
+	var prev [2]rune
+	i := 0
+	for _, r := range item {
+		r = unicode.ToLower(r)
+		add(unigram(r))
+		if i >= 2 {
+			add(trigram(prev[0], prev[1], r))
+		}
+		prev[0], prev[1] = prev[1], r
+		i++
+	}
+}
+
+// WriteTo writes the index in the file format (see 'Index').
+func (b *IndexBuilder) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	buf.WriteString(indexMagic)
+	buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(b.items))))
+	for _, item := range b.items {
+		buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(item))))
+		buf.WriteString(item)
+	}
+
+	grams := make([]uint64, 0, len(b.grams))
+	for gram := range b.grams {
+		grams = append(grams, gram)
+	}
+	slices.Sort(grams)
+	buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(grams))))
+	offset := uint64(0)
+	for _, gram := range grams {
+		p := b.grams[gram]
+		entry := binary.LittleEndian.AppendUint64(nil, gram)
+		entry = binary.LittleEndian.AppendUint64(entry, offset)
+		entry = binary.LittleEndian.AppendUint32(entry, p.count)
+		buf.Write(entry)
+		offset += uint64(len(p.data))
+	}
+	for _, gram := range grams {
+		buf.Write(b.grams[gram].data)
+	}
+	return buf.WriteTo(w)
+}
+
+var errCorruptIndex = errors.New("not an index file, or a corrupt one")
+
+// ReadIndex reads a whole index in the file format (see 'Index') into memory.
+func ReadIndex(r io.Reader) (*Index, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(indexMagic)) {
+		return nil, errCorruptIndex
+	}
+	data = data[len(indexMagic):]
+
+	readUint32 := func() (int, bool) {
+		if len(data) < 4 {
+			return 0, false
+		}
+		n := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		return int(n), true
+	}
+
+	x := &Index{}
+	count, ok := readUint32()
+	if !ok {
+		return nil, errCorruptIndex
+	}
+	// The items are substrings of one string of the whole item section, instead of a string each, so that they take
+	// no more memory than in the file. Item lengths are included, but they take little space.
+	section := data
+	ends := make([]int, 0, count)
+	for range count {
+		n, ok := readUint32()
+		if !ok || len(data) < n {
+			return nil, errCorruptIndex
+		}
+		data = data[n:]
+		ends = append(ends, len(section)-len(data))
+	}
+	text := string(section[:len(section)-len(data)])
+	x.items = make([]string, 0, count)
+	start := 0
+	for _, end := range ends {
+		x.items = append(x.items, text[start+4:end])
+		start = end
+	}
+
+	grams, ok := readUint32()
+	if !ok || len(data) < grams*indexEntrySize {
+		return nil, errCorruptIndex
+	}
+	// Copy the rest, so that the file data isn't kept in memory on top of the items.
+	data = bytes.Clone(data)
+	x.table, x.postings = data[:grams*indexEntrySize], data[grams*indexEntrySize:]
+	return x, nil
+}
+
+// Items returns the items, in the order they were added.
+func (x *Index) Items() []string {
+	return x.items
+}
+
+// Len returns the number of items.
+func (x *Index) Len() int {
+	return len(x.items)
+}
+
+// Candidates returns the numbers of the items that can match the pattern, in ascending order. Only these items need
+// to be matched. If the index can't narrow the pattern down, like when it only has inverted terms, then it returns
+// false and all items are candidates.
+//
+// A term set narrows the candidates down only if all of its terms do. Inverted terms, field-qualified terms,
+// comparisons and fuzzy terms that allow for typos don't.
+func (x *Index) Candidates(p Pattern) ([]int, bool) {
+	var candidates []int
+	filtered := false
+	for _, termSet := range p {
+		set, ok := x.termSetCandidates(termSet)
+		if !ok {
+			continue
+		}
+		if filtered {
+			candidates = intersect(candidates, set)
+		} else {
+			candidates, filtered = set, true
+		}
+	}
+	return candidates, filtered
+}
+
+// The candidates for a term set are those of any of its terms.
+func (x *Index) termSetCandidates(termSet []term) ([]int, bool) {
+	var candidates []int
+	for _, t := range termSet {
+		set, ok := x.termCandidates(t)
+		if !ok {
+			return nil, false
+		}
+		candidates = union(candidates, set)
+	}
+	return candidates, true
+}
+
+// The candidates for a term are the items that have all of its grams.
+func (x *Index) termCandidates(t term) ([]int, bool) {
+	if t.inv || t.field != "" || t.typ == termCompare || (t.typ == termFuzzy && t.approximate) {
+		return nil, false
+	}
+
+	var grams []uint64
+	if t.typ == termFuzzy || len(t.runes) < 3 {
+		for _, r := range t.runes {
+			grams = append(grams, unigram(r))
+		}
+	} else {
+		for i := 2; i < len(t.runes); i++ {
+			grams = append(grams, trigram(t.runes[i-2], t.runes[i-1], t.runes[i]))
+		}
+	}
+
+	// Start from the shortest posting list, so that the candidates only get fewer from there.
+	type entry struct {
+		offset uint64
+		count  uint32
+	}
+	var entries []entry
+	for _, gram := range grams {
+		offset, count, ok := x.lookup(gram)
+		if !ok {
+			// No item has the gram.
+			return []int{}, true
+		}
+		entries = append(entries, entry{offset, count})
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return int(a.count) - int(b.count) })
+
+	var candidates []int
+	for i, e := range entries {
+		list := x.postings[e.offset:]
+		if i == 0 {
+			candidates = make([]int, 0, min(int(e.count), len(list)))
+			n := 0
+			for range e.count {
+				delta, size := binary.Uvarint(list)
+				if size <= 0 || delta >= uint64(len(x.items)-n) {
+					break // A corrupt list. It only means fewer candidates.
+				}
+				list = list[size:]
+				n += int(delta)
+				candidates = append(candidates, n)
+			}
+			continue
+		}
+
+		// Keep the candidates that are in the posting list, decoding the list along the way.
+		kept := candidates[:0]
+		n, current, left := 0, -1, int(e.count)
+		for _, c := range candidates {
+			for left > 0 && current < c {
+				delta, size := binary.Uvarint(list)
+				if size <= 0 {
+					break // A corrupt list. It only means fewer candidates.
+				}
+				list = list[size:]
+				n += int(delta)
+				current = n
+				left--
+			}
+			if current == c {
+				kept = append(kept, c)
+			}
+		}
+		candidates = kept
+	}
+	return candidates, true
+}
+
+// Look up a gram in the gram table. It returns the offset and the length of its posting list.
+func (x *Index) lookup(gram uint64) (uint64, uint32, bool) {
+	entries := len(x.table) / indexEntrySize
+	i := sort.Search(entries, func(i int) bool {
+		return binary.LittleEndian.Uint64(x.table[i*indexEntrySize:]) >= gram
+	})
+	if i == entries {
+		return 0, 0, false
+	}
+	entry := x.table[i*indexEntrySize:]
+	if binary.LittleEndian.Uint64(entry) != gram {
+		return 0, 0, false
+	}
+	offset := binary.LittleEndian.Uint64(entry[8:])
+	if offset > uint64(len(x.postings)) {
+		return 0, 0, false
+	}
+	return offset, binary.LittleEndian.Uint32(entry[16:]), true
+}
+
+// The items that are in both of the ascending lists.
+func intersect(a, b []int) []int {
+	var result []int
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] < b[0]:
+			a = a[1:]
+		case a[0] > b[0]:
+			b = b[1:]
+		default:
+			result = append(result, a[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+	return result
+}
+
+// The items that are in either of the ascending lists.
+func union(a, b []int) []int {
+	if a == nil {
+		return b
+	}
+	result := make([]int, 0, max(len(a), len(b)))
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] < b[0]:
+			result, a = append(result, a[0]), a[1:]
+		case a[0] > b[0]:
+			result, b = append(result, b[0]), b[1:]
+		default:
+			result = append(result, a[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+	result = append(result, a...)
+	return append(result, b...)
+}
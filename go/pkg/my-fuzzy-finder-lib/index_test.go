@@ -0,0 +1,136 @@
+package my_fuzzy_finder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	items := []string{
+		"src/main.go",
+		"README.md",
+		"docs/GettingStarted.md",
+		"Straße/Köln.txt",
+		"pkg/finder/model.go",
+		"go.mod",
+		"a",
+	}
+
+	var b IndexBuilder
+	for _, item := range items {
+		b.Add(item)
+	}
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	x, err := ReadIndex(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(x.Items(), items) {
+		t.Fatalf("Items() = %v, want %v", x.Items(), items)
+	}
+
+	tests := map[string]struct {
+		query              string
+		expectedCandidates []int
+		expectedFiltered   bool
+	}{
+		"Fuzzy term": {
+			// A fuzzy term is narrowed down to the items with all of its characters, in any order.
+			query:              "mgo",
+			expectedCandidates: []int{0, 2, 4, 5},
+			expectedFiltered:   true,
+		},
+		"Substring term": {
+			query:              "'.md",
+			expectedCandidates: []int{1, 2},
+			expectedFiltered:   true,
+		},
+		"Trigrams must all be there": {
+			query:              "'main.go",
+			expectedCandidates: []int{0},
+			expectedFiltered:   true,
+		},
+		"Case and Unicode": {
+			query:              "^straße",
+			expectedCandidates: []int{3},
+			expectedFiltered:   true,
+		},
+		"OR operator": {
+			query:              "^readme | ^go.",
+			expectedCandidates: []int{1, 5},
+			expectedFiltered:   true,
+		},
+		"Multiple terms": {
+			query:              "'.go 'pkg",
+			expectedCandidates: []int{4},
+			expectedFiltered:   true,
+		},
+		"Unknown gram": {
+			query:              "'xyz",
+			expectedCandidates: nil,
+			expectedFiltered:   true,
+		},
+		"Inverted term": {
+			query:            "!.md",
+			expectedFiltered: false,
+		},
+		"Inverted term next to another term": {
+			query:              "!.md 'go",
+			expectedCandidates: []int{0, 2, 4, 5},
+			expectedFiltered:   true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pattern := BuildPattern(tt.query)
+			candidates, filtered := x.Candidates(pattern)
+			if filtered != tt.expectedFiltered {
+				t.Fatalf("Candidates() filtered = %v, want %v", filtered, tt.expectedFiltered)
+			}
+			if len(candidates) != len(tt.expectedCandidates) || (len(candidates) > 0 && !reflect.DeepEqual(candidates, tt.expectedCandidates)) {
+				t.Errorf("Candidates() = %v, want %v", candidates, tt.expectedCandidates)
+			}
+
+			// The index must never leave out an item that matches.
+			for i, item := range items {
+				if ok, _ := pattern.MatchItem(item); ok && filtered && !slices.Contains(candidates, i) {
+					t.Errorf("Candidates() left out the matching item %q", item)
+				}
+			}
+		})
+	}
+}
+
+func TestCorruptIndex(t *testing.T) {
+	// One item, and the posting list of "a" has item 5.
+	data := []byte(indexMagic)
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = binary.LittleEndian.AppendUint32(data, 3)
+	data = append(data, "abc"...)
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = binary.LittleEndian.AppendUint64(data, unigram('a'))
+	data = binary.LittleEndian.AppendUint64(data, 0)
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = binary.AppendUvarint(data, 5)
+
+	x, err := ReadIndex(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if candidates, _ := x.Candidates(BuildPattern("a")); len(candidates) != 0 {
+		t.Errorf("Candidates() = %v, want no items that aren't in the index", candidates)
+	}
+}
+
+func TestReadIndexRejectsOtherFiles(t *testing.T) {
+	if _, err := ReadIndex(bytes.NewBufferString("src/main.go\nREADME.md\n")); err == nil {
+		t.Error("ReadIndex() read a file that isn't an index")
+	}
+}
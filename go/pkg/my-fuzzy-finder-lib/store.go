@@ -0,0 +1,107 @@
+package my_fuzzy_finder
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Store holds items for matching them again and again, like on every keystroke. Matching an item of a store allocates
+// nothing unless it matches.
+//
+// Items are matched as they are, ignoring case as they go (see 'Pattern.MatchRecord'), so a store doesn't copy them.
+// It only refers to the strings that it's given, which are typically the strings that the caller keeps anyway. The
+// fields of record items are kept in one slice that all items share.
+//
+// The zero value is an empty store.
+type Store struct {
+	items  []storedItem
+	fields []Field
+}
+
+// storedItem is an item of a store. Its fields are 'fields[fieldStart:fieldStart+fieldCount]'.
+type storedItem struct {
+	text                   string
+	fieldStart, fieldCount uint32
+	record                 bool
+}
+
+// Add adds an item. When fields is nil, the item isn't a record (see 'Pattern.MatchRecord').
+func (s *Store) Add(text string, fields []Field) {
+	s.items = append(s.items, storedItem{
+		text:       text,
+		fieldStart: uint32(len(s.fields)),
+		fieldCount: uint32(len(fields)),
+		record:     fields != nil,
+	})
+	s.fields = append(s.fields, fields...)
+}
+
+// Len returns the number of items.
+func (s *Store) Len() int {
+	return len(s.items)
+}
+
+// Reset removes all items. The memory is kept for the next items.
+func (s *Store) Reset() {
+	clear(s.items)
+	clear(s.fields)
+	s.items = s.items[:0]
+	s.fields = s.fields[:0]
+}
+
+// Drop removes the first n items. The other items are shifted down in place, so that the memory stays capped when the
+// oldest items are dropped to make room for new ones.
+func (s *Store) Drop(n int) {
+	if n >= len(s.items) {
+		s.Reset()
+		return
+	}
+	if n <= 0 {
+		return
+	}
+
+	fieldShift := s.items[n].fieldStart
+	for i := range s.items[n:] {
+		item := s.items[n+i]
+		item.fieldStart -= fieldShift
+		s.items[i] = item
+	}
+	clear(s.items[len(s.items)-n:])
+	s.items = s.items[:len(s.items)-n]
+	copy(s.fields, s.fields[fieldShift:])
+	clear(s.fields[len(s.fields)-int(fieldShift):])
+	s.fields = s.fields[:len(s.fields)-int(fieldShift)]
+}
+
+// Match matches an item against the pattern. See 'Pattern.MatchRecord'.
+func (s *Store) Match(p Pattern, i int) (bool, RecordMatch) {
+	item := s.items[i]
+	var fields []Field
+	if item.record {
+		fields = s.fields[item.fieldStart : item.fieldStart+item.fieldCount]
+	}
+	return p.matchRecord(item.text, item.record, fields)
+}
+
+// Score rates how well an item matched, like 'Score'.
+func (s *Store) Score(i int, positions []int) int {
+	text := s.items[i].text
+
+	// The positions are rune offsets, and they are asked about in ascending order (see 'score'). So the text is walked
+	// along with them, instead of being converted to runes.
+	var (
+		offset    int  // The byte offset of the rune at 'index'
+		index     int  // The rune offset
+		prev, cur rune // The runes at 'index-1' and 'index'
+	)
+	cur, size := utf8.DecodeRuneInString(text)
+	return score(utf8.RuneCountInString(text), positions, func(pos int) bool {
+		for index < pos {
+			offset += size
+			prev = cur
+			cur, size = utf8.DecodeRuneInString(text[offset:])
+			index++
+		}
+		return isDelimiter(prev) || (unicode.IsLower(prev) && unicode.IsUpper(cur))
+	})
+}
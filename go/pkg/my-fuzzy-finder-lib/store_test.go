@@ -0,0 +1,95 @@
+package my_fuzzy_finder
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	items := []struct {
+		text   string
+		fields []Field
+	}{
+		{text: "src/Main.go"},
+		{text: "README.md file", fields: []Field{{Name: "name", Text: "README.md"}, {Name: "type", Text: "file"}}},
+		{text: "docs/GettingStarted.md"},
+		{text: "Straße/Köln.txt"},
+		{text: "pkg dir", fields: []Field{{Name: "name", Text: "pkg"}, {Name: "type", Text: "dir"}}},
+		{text: "line one\nsecond line"},
+	}
+	queries := []string{"md", "main", "gs", "'köln", "name:pkg", "!type:dir", "^second", "started$ | line"}
+
+	// The first item is dropped, so that the shifted items are checked too.
+	var s Store
+	for _, item := range items {
+		s.Add(item.text, item.fields)
+	}
+	s.Drop(1)
+	items = items[1:]
+	if s.Len() != len(items) {
+		t.Fatalf("Len() = %d, want %d", s.Len(), len(items))
+	}
+
+	for _, query := range queries {
+		pattern := BuildPattern(query).PerLine()
+		for i, item := range items {
+			t.Run(fmt.Sprintf("%s/%d", query, i), func(t *testing.T) {
+				expectedMatch, expected := pattern.MatchRecord(item.text, item.fields)
+				matched, m := s.Match(pattern, i)
+				if matched != expectedMatch || !reflect.DeepEqual(m, expected) {
+					t.Errorf("Match() = %v, %+v, want %v, %+v", matched, m, expectedMatch, expected)
+				}
+				if expectedScore := Score([]rune(item.text), expected.Positions); s.Score(i, m.Positions) != expectedScore {
+					t.Errorf("Score() = %d, want %d", s.Score(i, m.Positions), expectedScore)
+				}
+			})
+		}
+	}
+}
+
+// 1M paths, like from walking a big monorepo.
+func benchmarkPaths() []string {
+	dirs := []string{"src", "pkg", "internal", "cmd", "docs", "testdata", "vendor", "node_modules", "build", "scripts"}
+	names := []string{"main", "README", "handler", "UserService", "config", "parser", "index", "utils", "server", "client"}
+	exts := []string{".go", ".md", ".ts", ".json", ".yaml"}
+	var paths []string
+	for i := 0; len(paths) < 1_000_000; i++ {
+		paths = append(paths, fmt.Sprintf("%s/%s/module%d/%s%d%s", dirs[i%len(dirs)], dirs[(i/10)%len(dirs)], i%997, names[(i/100)%len(names)], i%13, exts[i%len(exts)]))
+	}
+	return paths
+}
+
+// Match 1M paths one by one. This only uses the API that the package has always had, so it can be compared with older
+// versions of the package.
+func BenchmarkMatchItem(b *testing.B) {
+	paths := benchmarkPaths()
+	pattern := BuildPattern("usrsvc.go")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		for _, path := range paths {
+			if ok, positions := pattern.MatchItem(path); ok {
+				Score([]rune(path), positions)
+			}
+		}
+	}
+}
+
+// Match 1M paths that were added to a store once, like the finder does.
+func BenchmarkStoreMatch(b *testing.B) {
+	var s Store
+	for _, path := range benchmarkPaths() {
+		s.Add(path, nil)
+	}
+	pattern := BuildPattern("usrsvc.go")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		for i := range s.Len() {
+			if ok, m := s.Match(pattern, i); ok {
+				s.Score(i, m.Positions)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Wait for the next lines from 'followFile'.
+func expectLines(t *testing.T, lines <-chan string, expected ...string) {
+	t.Helper()
+	for _, want := range expected {
+		select {
+		case line := <-lines:
+			if line != want {
+				t.Fatalf("followFile() sent %q, want %q", line, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("followFile() didn't send %q", want)
+		}
+	}
+}
+
+func appendTo(t *testing.T, path, text string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowFile(t *testing.T) {
+	tests := map[string]func(t *testing.T, path string, lines <-chan string){
+		"Append": func(t *testing.T, path string, lines <-chan string) {
+			appendTo(t, path, "c\nd")
+			expectLines(t, lines, "c")
+			appendTo(t, path, "e\n")
+			expectLines(t, lines, "de")
+		},
+		"Truncate": func(t *testing.T, path string, lines <-chan string) {
+			if err := os.WriteFile(path, []byte("x\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			expectLines(t, lines, "x")
+			appendTo(t, path, "y\n")
+			expectLines(t, lines, "y")
+		},
+		"Rotate": func(t *testing.T, path string, lines <-chan string) {
+			appendTo(t, path, "c")
+			if err := os.Rename(path, path+".1"); err != nil {
+				t.Fatal(err)
+			}
+			appendTo(t, path, "new\n")
+			expectLines(t, lines, "c", "new")
+			appendTo(t, path, "newer\n")
+			expectLines(t, lines, "newer")
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "log")
+			if err := os.WriteFile(path, []byte("a\nb\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			lines := make(chan string, 10)
+			if err := followFile(path, lines); err != nil {
+				t.Fatal(err)
+			}
+			expectLines(t, lines, "a", "b")
+			test(t, path, lines)
+		})
+	}
+}
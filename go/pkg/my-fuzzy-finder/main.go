@@ -1,8 +1,15 @@
 // See the README file for more information about the `my-fuzzy-finder` program.
 //
+// The program is split up like this:
+//   - This file is the command-line interface: flags, input formats, the file walker, the frecency store, the 'index'
+//     subcommand and the output formats. Watching a followed file is platform-specific, so it's in 'watch_linux.go' and
+//     'watch_other.go'.
+//   - The interactive finder is the 'finder' package, so that other programs can embed it.
+//   - The matching is the 'my-fuzzy-finder-lib' package, and the NUON input and output is the 'nuon' package.
+//
 // One principle that I'm taking with this program design is "there's no need for extensibility". In particular:
-//   - The source code is one file (plus dependencies)
-//   - The program uses plenty of global variables for things that are constant (style definitions, keybinding definitions, etc.)
+//   - Code is only split into packages where another program reuses it, not into layers. Within a package, the code
+//     stays in a few big files.
 //   - The program does not define any interfaces.
 //   - There is very little abstraction of code into functions. If a bit of code is not re-used, it doesn't need to be in
 //     its own function. (If I had something really complicated that could be expressed succinctly in a function signature
@@ -14,21 +21,39 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	_ "embed"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/charmbracelet/bubbles/cursor"
-	"github.com/charmbracelet/bubbles/textinput"
-	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/muesli/termenv"
 	"io"
 	"log"
+	"my-software/pkg/finder"
 	fz "my-software/pkg/my-fuzzy-finder-lib"
+	"my-software/pkg/nuon"
+	"net"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// nushellIntegration is the Nushell module printed by '--nushell-integration'.
+//
+//go:embed resources/nushell-integration.nu
+var nushellIntegration string
+
 // NoMatchExitCode is an exit code that indicates no item matched. This is the same meaning used by fzf.
 const NoMatchExitCode = 1
 
@@ -36,291 +61,181 @@ const NoMatchExitCode = 1
 // meaning used by fzf.
 const NoSelectionExitCode = 130
 
-// The master list of items
-var allItems []string
-
-var realFrame = lipgloss.NewStyle().Margin(1, 2)
-var noFrame = lipgloss.NewStyle()
-var styleNormalTitle = lipgloss.NewStyle().Foreground(lipgloss.Color("0"))
-var styleNormalTitleBox = lipgloss.NewStyle().Padding(0, 0, 0, 2)
-var styleSelectedTitle = lipgloss.NewStyle().Foreground(lipgloss.Color("#DA5CE4"))
-var styleSelectedTitleBox = lipgloss.NewStyle().
-	Border(lipgloss.NormalBorder(), false, false, false, true).
-	BorderForeground(lipgloss.Color("#DA5CE4")).
-	Padding(0, 0, 0, 1)
-var styleFilterPrompt = lipgloss.NewStyle().
-	Foreground(lipgloss.Color("100"))
-var styleFilterCursor = lipgloss.NewStyle().
-	Foreground(lipgloss.Color("#DA5CE4"))
-var styleNoItems = lipgloss.NewStyle().
-	Foreground(lipgloss.Color("245"))
-var prompt = "Filter: "
-var promptLength = len(prompt)
-
-type model struct {
-	input                  textinput.Model
-	cursor                 cursor.Model
-	height                 int
-	item                   int
-	matches                []Match
-	pages                  [][]Match
-	page                   int
-	pageItem               int
-	completedWithSelection bool
-	frame                  lipgloss.Style
-}
-
-func (m model) Init() tea.Cmd {
-	return textinput.Blink
+// UsageExitCode is an exit code that indicates invalid flags or flag values, like an unknown tiebreak criterion or a
+// replay script that doesn't parse. This is also the exit code of the 'flag' package.
+const UsageExitCode = 2
+
+// InputExitCode is an exit code that indicates that the input couldn't be read or decoded.
+const InputExitCode = 3
+
+// TerminalExitCode is an exit code that indicates that the finder couldn't run, like when there's no TTY.
+const TerminalExitCode = 4
+
+// SystemExitCode is an exit code that indicates that something other than the input or the terminal failed, like the
+// remote-control listener, the frecency store or writing the output.
+const SystemExitCode = 5
+
+// Failure is the JSON shape of an error on stderr, with '--json-errors'. Every non-zero exit is reported like this,
+// including the "no_match" and "aborted" exits.
+type Failure struct {
+	// The failure class: "no_match", "aborted", "usage", "input", "terminal" or "system". Each class has its own exit
+	// code.
+	Code     string `json:"code"`
+	ExitCode int    `json:"exit_code"`
+	Message  string `json:"message"`
+	// Details about the failure, if there are any. For example, a decoding error has the byte offset of the error in
+	// the input ("offset") or its "line" and "column".
+	Details map[string]any `json:"details,omitempty"`
 }
 
-func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	log.Printf("[Update] tea.Msg: %+v\n", msg)
-	var cmds = make([]tea.Cmd, 1)
-	oldInput := m.input.Value()
-	m.input, cmds[0] = m.input.Update(msg)
-
-	switch msg := msg.(type) {
-	case tea.WindowSizeMsg:
-		var hz, v int
-		// We want a frame, but only if there is enough space
-		if msg.Height > 10 && msg.Width > 50 {
-			hz, v = realFrame.GetFrameSize()
-			m.frame = realFrame
-		} else {
-			m.frame = noFrame
-		}
-
-		log.Printf("WindowSizeMsg: %+v Frame size: hz=%d, v=%d\n", msg, hz, v)
-		m.height = msg.Height - v
-		m.input.Width = msg.Width - hz - promptLength
-		return pageReflow(m), tea.Batch(cmds...)
-	case tea.KeyMsg:
-		k := msg.String()
-		switch k {
-		case "ctrl+c", "esc":
-			cmds = append(cmds, tea.Quit)
-			return m, tea.Batch(cmds...)
-		case "enter":
-			m.completedWithSelection = true
-			cmds = append(cmds, tea.Quit)
-			return m, tea.Batch(cmds...)
-		case "up":
-			log.Println("Handling key press 'up'...")
-			if m.item == -1 {
-				log.Println("There are no items to select. Key press 'up' is a no-op.")
-				return m, tea.Batch(cmds...)
-			}
-
-			if m.pageItem == 0 {
-				if m.page == 0 {
-					log.Println("Already selected the first item of the first page. Key press 'up' is a no-op here.")
-					return m, tea.Batch(cmds...)
-				}
-
-				log.Println("Turning to the previous page, and selecting the last item on it.")
-				m.page--
-				m.pageItem = len(m.pages[m.page]) - 1
-			} else {
-				log.Println("Selecting the previous item in the page.")
-				m.pageItem--
-			}
-
-			m.item = m.pages[m.page][m.pageItem].Index
-			return m, tea.Batch(cmds...)
-		case "down":
-			log.Println("Handling key press 'down'...")
-			if m.item == -1 {
-				log.Println("There are no items to select. Key press 'down' is a no-op.")
-				return m, tea.Batch(cmds...)
-			}
-
-			pageSize := len(m.pages[m.page])
-			if m.pageItem == pageSize-1 {
-				if m.page == len(m.pages)-1 {
-					log.Println("Already selected the last item of the last page. Key press 'down' is a no-op here.")
-					return m, tea.Batch(cmds...)
-				}
-
-				log.Println("Turning to the next page.")
-				m.page++
-				m.pageItem = 0
-			} else {
-				log.Println("Selecting the next item in the page.")
-				m.pageItem++
-			}
-
-			m.item = m.pages[m.page][m.pageItem].Index
-			return m, tea.Batch(cmds...)
-		default: // Assume some text was entered in the filter input.
-			newInput := m.input.Value()
-			if oldInput != newInput {
-				log.Printf("[Update] Filter changed. Was '%+v', now '%+v'. Must re-execute fuzzy finding and re-flow the pages...\n", oldInput, newInput)
-				if m.input.Value() == "" {
-					log.Println("No input. Skip fuzzy matching.")
-					m.matches = nil
-				} else {
-					// Use "fzf" (https://github.com/junegunn/fzf) to filter through the list.
-					//
-					//"fzf" is not available as a library (https://github.com/junegunn/fzf/pull/1053#issuecomment-330024275),
-					// which is totally fine. While there are other Go-based fuzzy finders, I want the power and API of
-					// "fzf". To make it work, I copied (should I say "vendored"?) the code I needed from the "fzf"
-					// codebase into this codebase.
-					//
-					// From a TUI perspective, this is a "dirty programming pattern" because this is a relatively slow
-					// operation, and we're doing it on the UI thread. You are "supposed" to use a Go routine and
-					//message passing. But in practice, it's exactly what I want.
-					matches := MatchAll(m.input.Value(), allItems)
-					m.matches = matches
-				}
-				return pageReflow(m), tea.Batch(cmds...)
-			}
-
-			return m, tea.Batch(cmds...)
-		}
-	default:
-		log.Printf("Unexpected message: %+v\n", msg)
-		return m, tea.Batch(cmds...)
-	}
-}
-
-func (m model) View() string {
-	log.Println("[View]")
-	var (
-		sections    []string
-		availHeight = m.height
-	)
-
-	v := m.input.View()
-	sections = append(sections, v)
-	availHeight -= lipgloss.Height(v)
-
-	content := lipgloss.NewStyle().Height(availHeight).Render(m.populatedView())
-	sections = append(sections, content)
-	return m.frame.Render(lipgloss.JoinVertical(lipgloss.Left, sections...))
+// ReturnItem is the JSON output shape of a selected item.
+type ReturnItem struct {
+	// The index of the item in the input. For de-duplicated items, this is the index of the first occurrence.
+	Index int `json:"index"`
+	// For de-duplicated items, the indices of all occurrences in the input.
+	Indices []int `json:"indices,omitempty"`
+	// The item text, or for tabular input, the row as a JSON object keyed by the header.
+	Value any `json:"value"`
 }
 
-func (m model) FilterValue() string {
-	return m.input.Value()
+// Result is the JSON output shape used when the accepting key or the final query are requested (see '--expect' and
+// '--print-query'). An empty key means the selection was accepted with "enter".
+type Result struct {
+	Key       *string      `json:"key,omitempty"`
+	Query     *string      `json:"query,omitempty"`
+	Selection []ReturnItem `json:"selection"`
 }
 
-// "Reflow" the selected items into a new page set. Consider that many one-line items can occupy one page whereas
-// multi-line items take up more space, and thus more pages.
-//
-// This function also re-calculates the selected item and the page/page-item cursors.
-func pageReflow(m model) model {
-	var matches []Match
-	if m.input.Value() == "" {
-		log.Println("No input. Create fake matches for all items so that the pages can get created.")
-		matches = Map(allItems, func(item string, i int) Match {
-			return Match{Index: i}
-		})
-	} else {
-		if len(m.matches) == 0 {
-			log.Println("No matches were found. There is nothing to reflow.")
-			m.item = -1
-			m.pages = nil
-			m.page = -1
-			m.pageItem = -1
-			return m
-		} else {
-			log.Printf("Reflowing against %d matches...\n", len(m.matches))
-			log.Printf("matches: %+v\n", m.matches)
-			matches = m.matches
-		}
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "index" {
+		indexCommand(os.Args[2:])
+		return
 	}
 
-	availHeight := m.height
-	titleHeight := lipgloss.Height(m.input.View())
-	availHeight -= titleHeight
-	log.Printf("[pageReflow] titleHeight=%d availHeight=%d\n", titleHeight, availHeight)
+	var opts finder.Options
 
-	pages := make([][]Match, 0)
-	page := make([]Match, 0)
-	heightBudget := availHeight
+	// Parse errors are reported like the other failures (see 'fail'), so the flag package mustn't exit by itself. Its
+	// messages and the usage are buffered until it's known whether they are wanted.
+	var usage bytes.Buffer
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	flag.CommandLine.SetOutput(&usage)
 
-	prevItem := m.item
-	closestDistance := len(allItems)
-
-	for _, match := range matches {
-		itemHeight := lipgloss.Height(allItems[match.Index])
-		if itemHeight > heightBudget {
-			// We need to spill over to a new page. Complete the page we were working on.
-			pages = append(pages, page)
-			page = make([]Match, 0)
-			heightBudget = availHeight // TODO handle when an item is larger than a whole page.
+	debug := flag.Bool("debug", false, "Enable debug logging to file")
+	example := flag.Bool("example", false, "Run with example data")
+	jsonIn := flag.Bool("json-in", false, "JSON array in. The items can be strings or structured values like records.")
+	jsonOut := flag.Bool("json-out", false, "JSON out")
+	nuonIn := flag.Bool("nuon-in", false, "NUON list in")
+	nuonOut := flag.Bool("nuon-out", false, "NUON out")
+	csvIn := flag.Bool("csv", false, "CSV in, with a header row. Rows are displayed as aligned columns.")
+	tsvIn := flag.Bool("tsv", false, "TSV in, with a header row. Rows are displayed as aligned columns.")
+	matchColumnsFlag := flag.String("match-columns", "", "For CSV/TSV input, a comma-separated list of the column names to match against. By default, all columns.")
+	flag.StringVar(&opts.DisplayField, "display-field", "", "For record items, the field to display and match against. By default, the whole record is displayed. Query terms can be qualified with a field name to match other fields, like 'name:readme !type:dir size:>1mb'.")
+	flag.StringVar(&opts.GroupBy, "group-by", "", "For record items (e.g. with '--csv' or '--json-in'), group the items by a field. Each group is shown under a header with the field's text, and the groups are ordered by their best match.")
+	expect := flag.String("expect", "", "Comma-separated list of keys (e.g. 'ctrl-o,alt-c') that accept the selection, in addition to 'enter'. The key used is reported in the output.")
+	printQuery := flag.Bool("print-query", false, "Report the final query in the output")
+	flag.BoolVar(&opts.ANSI, "ansi", false, "Process ANSI color codes in the input. The codes are kept for display, but they aren't matched against and they are stripped from the output.")
+	flag.BoolVar(&opts.KeepANSI, "keep-ansi", false, "With '--ansi', output the original item text with its ANSI color codes")
+	flag.BoolVar(&opts.Dedupe, "dedupe", false, "Collapse identical items into one item. The output has the indices of all occurrences.")
+	flag.BoolVar(&opts.ShowCount, "show-count", false, "With '--dedupe', show how many times each item occurred (e.g. '×3')")
+	flag.BoolVar(&opts.RankByFrequency, "rank-by-frequency", false, "With '--dedupe', rank items that occurred more often first")
+	flag.BoolVar(&opts.MatchingLines, "matching-lines", false, "For multi-line items, apply the '^' and '$' anchors to each line, and show only the matching lines (with line numbers) plus some context")
+	flag.IntVar(&opts.Context, "context", 2, "With '--matching-lines', the number of lines of context to show around each matching line")
+	flag.IntVar(&opts.ScrollOff, "scroll-off", 3, "The number of lines to keep visible above and below the cursor when scrolling. Use 'pgup' and 'pgdn' to scroll by a screen.")
+	flag.BoolVar(&opts.Wrap, "wrap", false, "Wrap long lines instead of truncating them with an ellipsis")
+	flag.BoolVar(&opts.NoSort, "no-sort", false, "Keep matches in input order instead of sorting them by score. Use 'ctrl-s' to toggle sorting at runtime.")
+	flag.BoolVar(&opts.Tac, "tac", false, "Reverse the order of the input")
+	flag.BoolVar(&opts.Exact, "exact", false, "Match plain terms as substrings instead of fuzzily. A term that starts with \"'\" is matched fuzzily instead. Use 'alt-e' to toggle this at runtime.")
+	flag.BoolVar(&opts.Typos, "typos", false, "Let fuzzy terms also match with a few typos (e.g. 'cnofig' for 'config'). These matches are ranked below the exact ones. Use 'ctrl-t' to toggle this at runtime.")
+	tiebreak := flag.String("tiebreak", "length", "Comma-separated list of criteria for ordering matches with the same score: 'length' (shorter first), 'begin' (match closer to the beginning first), 'end' (match closer to the end first) and 'index' (input order first)")
+	frecencyKey := flag.String("frecency-key", "", "Rank items that were selected recently and often higher. Selections are remembered per key (e.g. 'projects') in a local store.")
+	walker := flag.String("walker", "file,follow", "When nothing is piped in, the current directory is walked for items. This is a comma-separated list of walker options: 'file' (include files), 'dir' (include directories), 'hidden' (include hidden files and directories) and 'follow' (follow symbolic links).")
+	nushellIntegrationFlag := flag.Bool("nushell-integration", false, "Print a Nushell module with keybindings for picking files (ctrl-t), history (ctrl-r) and directories (alt-c), and exit")
+	indexFile := flag.String("index", "", "Use an index file instead of the input, for very large corpora. The items are the indexed items, and the index narrows down which of them a query can match. Build an index with 'my-fuzzy-finder index build <file>'.")
+	follow := flag.String("follow", "", "Follow a file like 'tail -f'. Its lines are items, and new lines are added as they are appended to the file.")
+	flag.IntVar(&opts.MaxItems, "max-items", 0, "Keep at most this many items. When more items stream in (e.g. with '--follow'), the oldest items are dropped, a quarter of this many at a time.")
+	replay := flag.String("replay", "", "Run headlessly, without a terminal, and replay the scripted session in the given file instead of reading keys. The final selection is output as usual. See the 'finder.ParseScript' function for the script format.")
+	dumpFrames := flag.String("dump-frames", "", "With '--replay', write the rendered screen after each step of the script to a file in the given directory (e.g. 'frame-0001.txt')")
+	jsonErrors := flag.Bool("json-errors", false, "Report errors on stderr as JSON objects like '{\"code\": \"input\", \"exit_code\": 3, \"message\": \"...\", \"details\": {\"offset\": 42}}' instead of text. Every non-zero exit is reported, including 'no_match' (exit code 1) and 'aborted' (exit code 130). The other codes are 'usage' (2), 'input' (3), 'terminal' (4) and 'system' (5).")
+	listen := flag.String("listen", "", "Listen for remote-control requests on a Unix socket path, or on a localhost port (e.g. '6266' or 'localhost:6266')")
+	flag.Func("bind", "Bind a key to an action, like 'ctrl-r:reload(git branch --all)'. Supported actions are 'accept', 'abort', 'toggle-sort' (bound to 'ctrl-s' by default), 'toggle-typos' (bound to 'ctrl-t' by default), 'toggle-exact' (bound to 'alt-e' by default), 'jump' (bound to 'ctrl-j' by default), 'jump-accept' and 'reload(command)'. Can be repeated.", func(spec string) error {
+		name, a, ok := strings.Cut(spec, ":")
+		if !ok || name == "" {
+			return fmt.Errorf("expected 'key:action' but got '%s'", spec)
 		}
 
-		page = append(page, match)
-		heightBudget -= itemHeight
-
-		distance := prevItem - match.Index
-		if distance < 0 {
-			distance = -distance
+		action, err := finder.ParseAction(a)
+		if err != nil {
+			return err
 		}
-
-		if distance < closestDistance {
-			m.item = match.Index
-			m.page = len(pages)
-			m.pageItem = len(page) - 1
-			closestDistance = distance
+		if opts.Bindings == nil {
+			opts.Bindings = map[string]finder.Action{}
 		}
+		opts.Bindings[name] = action
+		return nil
+	})
+	parseErr := flag.CommandLine.Parse(os.Args[1:])
+	flag.CommandLine.SetOutput(nil)
+
+	// Exit with the exit code of the failure class, and report the failure on stderr (see 'reportFailure').
+	fail := func(f Failure) {
+		reportFailure(os.Stderr, *jsonErrors, f)
+		os.Exit(f.ExitCode)
 	}
+	noMatch := Failure{Code: "no_match", ExitCode: NoMatchExitCode, Message: "No item matched"}
 
-	pages = append(pages, page)
-	m.pages = pages
-	return m
-}
-
-func (m model) populatedView() string {
-	log.Println("[populatedView]")
-
-	var b strings.Builder
-
-	if len(m.pages) == 0 {
-		return styleNoItems.Render("No matches.")
+	if errors.Is(parseErr, flag.ErrHelp) {
+		os.Stderr.Write(usage.Bytes())
+		return
+	}
+	if parseErr != nil {
+		// Parsing stops at the first bad flag, which may come before '--json-errors'.
+		*jsonErrors = jsonErrorsArg(os.Args[1:])
+		if !*jsonErrors {
+			os.Stderr.Write(usage.Bytes())
+			os.Exit(UsageExitCode)
+		}
+		fail(Failure{Code: "usage", ExitCode: UsageExitCode, Message: parseErr.Error()})
 	}
 
-	matches := m.pages[m.page]
-
-	for i, match := range matches {
-		item := allItems[match.Index]
+	if *nushellIntegrationFlag {
+		fmt.Print(nushellIntegration)
+		return
+	}
 
-		var style lipgloss.Style
-		var blockStyle lipgloss.Style
+	walking := false
 
-		if i == m.pageItem {
-			style = styleSelectedTitle
-			blockStyle = styleSelectedTitleBox
-		} else {
-			style = styleNormalTitle
-			blockStyle = styleNormalTitleBox
+	for _, criterion := range strings.Split(*tiebreak, ",") {
+		criterion = strings.TrimSpace(criterion)
+		if !slices.Contains([]string{"length", "begin", "end", "index"}, criterion) {
+			fail(Failure{Code: "usage", ExitCode: UsageExitCode, Message: fmt.Sprintf("Unknown tiebreak criterion '%s'", criterion)})
 		}
-
-		item = underlineMatches(item, match.Positions, style)
-		item = blockStyle.Render(item)
-
-		if i != len(matches)-1 {
-			item = item + "\n"
+		opts.Tiebreaks = append(opts.Tiebreaks, criterion)
+		if criterion == "index" {
+			break // The rest would never be reached.
 		}
-
-		fmt.Fprintf(&b, "%s", item)
 	}
 
-	return b.String()
-}
-
-type ReturnItem struct {
-	Index int    `json:"index"`
-	Value string `json:"value"`
-}
+	var script finder.Script
+	if *replay != "" {
+		f, err := os.Open(*replay)
+		if err == nil {
+			script, err = finder.ParseScript(f)
+			f.Close()
+		}
+		if err != nil {
+			fail(Failure{Code: "usage", ExitCode: UsageExitCode, Message: fmt.Sprintf("Error loading the replay script '%s': %v", *replay, err)})
+		}
+	}
 
-func main() {
-	debug := flag.Bool("debug", false, "Enable debug logging to file")
-	example := flag.Bool("example", false, "Run with example data")
-	jsonIn := flag.Bool("json-in", false, "JSON array in")
-	jsonOut := flag.Bool("json-out", false, "JSON out")
-	flag.Parse()
+	if *expect != "" {
+		for _, name := range strings.Split(*expect, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			opts.Expect = append(opts.Expect, name)
+		}
+	}
 
 	// When the program is executed in a certain way, like when it is part of piped commands on the commandline, Bubble
 	// Tea (or rather, the machinery used by Bubble Tea) won't enable colors. We can force colors.
@@ -330,16 +245,36 @@ func main() {
 	if *debug {
 		f, err := os.OpenFile("my-fuzzy-finder.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 		if err != nil {
-			log.Fatalf("error opening File: %v\n", err)
+			fail(Failure{Code: "system", ExitCode: SystemExitCode, Message: fmt.Sprintf("Error opening the debug log file: %v", err)})
 		}
 		defer f.Close()
 		log.SetOutput(f)
+		opts.Log = log.Default()
 	} else {
 		log.SetOutput(io.Discard)
 	}
 
-	if *example {
-		allItems = []string{
+	if *indexFile != "" {
+		f, err := os.Open(*indexFile)
+		if err != nil {
+			fail(Failure{Code: "input", ExitCode: InputExitCode, Message: fmt.Sprintf("Error reading the index: %v", err)})
+		}
+		index, err := fz.ReadIndex(bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			fail(Failure{Code: "input", ExitCode: InputExitCode, Message: fmt.Sprintf("Error reading the index '%s': %v", *indexFile, err)})
+		}
+		opts.Items = index.Items()
+		opts.Index = index
+	} else if *follow != "" {
+		// The lines are streamed in when the program is running. This includes the lines that are already in the file.
+		lines := make(chan string, 1024)
+		if err := followFile(*follow, lines); err != nil {
+			fail(Failure{Code: "input", ExitCode: InputExitCode, Message: fmt.Sprintf("Error following the file: %v", err)})
+		}
+		opts.Stream = batch(lines)
+	} else if *example {
+		opts.Items = []string{
 			"Eight hours of sleep",
 			"French press",
 			"Dear Reader,\nHello.",
@@ -351,155 +286,845 @@ func main() {
 			"🏓 Table 🏓 tennis 🏓",
 			"Terrycloth",
 		}
+	} else if *csvIn || *tsvIn {
+		reader := csv.NewReader(os.Stdin)
+		if *tsvIn {
+			reader.Comma = '\t'
+			reader.LazyQuotes = true
+		}
+		records, err := reader.ReadAll()
+		if err != nil {
+			fail(Failure{Code: "input", ExitCode: InputExitCode, Message: fmt.Sprintf("Error decoding CSV input: %v", err), Details: decodeErrorDetails(err)})
+		}
+		if len(records) == 0 {
+			fail(noMatch)
+		}
+
+		table := finder.Table{Header: records[0], Rows: records[1:]}
+		if *matchColumnsFlag != "" {
+			for _, name := range strings.Split(*matchColumnsFlag, ",") {
+				i := slices.Index(table.Header, strings.TrimSpace(name))
+				if i == -1 {
+					fail(Failure{Code: "usage", ExitCode: UsageExitCode, Message: fmt.Sprintf("Unknown match column '%s'. The columns are: %s", name, strings.Join(table.Header, ", "))})
+				}
+				table.MatchColumns = append(table.MatchColumns, i)
+			}
+		}
+		opts.Table = &table
+	} else if *nuonIn {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fail(Failure{Code: "input", ExitCode: InputExitCode, Message: fmt.Sprintf("Error reading standard input: %v", err)})
+		}
+		v, err := nuon.Parse(string(data))
+		if err != nil {
+			fail(Failure{Code: "input", ExitCode: InputExitCode, Message: fmt.Sprintf("Error decoding NUON input: %v", err), Details: decodeErrorDetails(err)})
+		}
+		if v.Kind != nuon.List {
+			fail(Failure{Code: "input", ExitCode: InputExitCode, Message: "Error decoding NUON input: expected a list"})
+		}
+
+		opts.Values = v.List
 	} else if *jsonIn {
+		var elements []json.RawMessage
 		decoder := json.NewDecoder(os.Stdin)
-		err := decoder.Decode(&allItems)
+		err := decoder.Decode(&elements)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error decoding JSON input: %v", err)
-			os.Exit(1)
+			fail(Failure{Code: "input", ExitCode: InputExitCode, Message: fmt.Sprintf("Error decoding JSON input: %v", err), Details: decodeErrorDetails(err)})
 		}
+
+		// A list of strings is the common case. Anything else (e.g. records of shell history) is kept as structured
+		// values. JSON is a subset of NUON, so the NUON parser does the work.
+		structured := slices.ContainsFunc(elements, func(e json.RawMessage) bool { return e[0] != '"' })
+		for i, e := range elements {
+			if !structured {
+				var item string
+				json.Unmarshal(e, &item)
+				opts.Items = append(opts.Items, item)
+				continue
+			}
+			v, err := nuon.Parse(string(e))
+			if err != nil {
+				// The offset of a syntax error is relative to the element.
+				details := decodeErrorDetails(err)
+				if details == nil {
+					details = map[string]any{}
+				}
+				details["element"] = i
+				fail(Failure{Code: "input", ExitCode: InputExitCode, Message: fmt.Sprintf("Error decoding JSON input: element %d: %v", i, err), Details: details})
+			}
+			opts.Values = append(opts.Values, v)
+		}
+	} else if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		// Nothing is piped in, so walk the current directory for items instead. The paths are streamed in when the
+		// program is running.
+		walking = true
 	} else {
 		scanner := bufio.NewScanner(os.Stdin)
-		i := 0
 		for scanner.Scan() {
-			allItems = append(allItems, scanner.Text())
-			i++
+			opts.Items = append(opts.Items, scanner.Text())
 		}
 		if err := scanner.Err(); err != nil {
-			fmt.Fprintf(os.Stderr, "error reading standard input: %v", err)
-			os.Exit(1)
+			fail(Failure{Code: "input", ExitCode: InputExitCode, Message: fmt.Sprintf("Error reading standard input: %v", err)})
 		}
 	}
 
-	if len(allItems) == 0 {
-		os.Exit(NoMatchExitCode)
+	var store frecencyStore
+	if *frecencyKey != "" {
+		var err error
+		if store, err = loadFrecencyStore(*frecencyKey); err != nil {
+			fail(Failure{Code: "system", ExitCode: SystemExitCode, Message: fmt.Sprintf("Error loading the frecency store: %v", err)})
+		}
+		now := time.Now()
+		opts.Frecency = make(map[string]float64, len(store.Entries))
+		for item, entry := range store.Entries {
+			opts.Frecency[item] = entry.score(now)
+		}
 	}
 
-	input := textinput.New()
-	input.PromptStyle = styleFilterPrompt
-	input.Prompt = prompt
-	input.CharLimit = 64
-	input.Cursor.Style = styleFilterCursor
-	input.Focus()
+	if opts.GroupBy != "" && opts.Values == nil && opts.Table == nil {
+		fail(Failure{Code: "usage", ExitCode: UsageExitCode, Message: "'--group-by' needs record items, like from '--csv', '--tsv', '--nuon-in' or '--json-in'"})
+	}
 
-	// Force Bubble Tea to output to the TTY. If we don't do this, then when the program is part of a pipeline, the TUI
-	// isn't rendered. This problem, explanation, and work around is well described here: https://github.com/charmbracelet/bubbletea/issues/860#issue-1983089654
-	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error opening /dev/tty:", err)
-		os.Exit(2)
+	if len(opts.Items) == 0 && len(opts.Values) == 0 && (opts.Table == nil || len(opts.Table.Rows) == 0) && !walking && *follow == "" {
+		fail(noMatch)
 	}
-	defer tty.Close()
 
-	p := tea.NewProgram(model{
-		input: input,
-	}, tea.WithAltScreen(), tea.WithOutput(tty))
+	if *listen != "" {
+		network, address := "unix", *listen
+		if _, err := strconv.Atoi(strings.TrimPrefix(*listen, "localhost:")); err == nil {
+			network, address = "tcp", "localhost:"+strings.TrimPrefix(*listen, "localhost:")
+		}
+
+		// A socket file that is left over from a finder that didn't exit cleanly would be in the way. It's stale if
+		// nothing answers on it.
+		if network == "unix" {
+			if conn, err := net.Dial(network, address); err == nil {
+				conn.Close()
+			} else if info, statErr := os.Stat(address); statErr == nil && info.Mode()&os.ModeSocket != 0 {
+				os.Remove(address)
+			}
+		}
+		listener, err := net.Listen(network, address)
+		if err != nil {
+			fail(Failure{Code: "system", ExitCode: SystemExitCode, Message: fmt.Sprintf("Error listening for remote-control requests: %v", err)})
+		}
+		opts.Listener = listener
+	}
 
-	finalMUncast, err := p.Run()
+	if walking {
+		var options walkerOptions
+		for _, option := range strings.Split(*walker, ",") {
+			switch strings.TrimSpace(option) {
+			case "file":
+				options.files = true
+			case "dir":
+				options.dirs = true
+			case "hidden":
+				options.hidden = true
+			case "follow":
+				options.follow = true
+			default:
+				fail(Failure{Code: "usage", ExitCode: UsageExitCode, Message: fmt.Sprintf("Unknown walker option '%s'", option)})
+			}
+		}
 
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error running program:", err)
-		os.Exit(2)
+		paths := make(chan string, 1024)
+		go func() {
+			walkFiles(".", options, paths)
+			close(paths)
+		}()
+		opts.Stream = batch(paths)
 	}
 
-	if finalMUncast == nil {
-		fmt.Fprintln(os.Stderr, "The final model was nil. This is unexpected.")
-		os.Exit(2)
+	var result finder.Result
+	var err error
+	runFailure := Failure{Code: "terminal", ExitCode: TerminalExitCode}
+	if *replay != "" {
+		// There's no terminal to fail, so a failure is writing the frames.
+		runFailure = Failure{Code: "system", ExitCode: SystemExitCode}
+		result, err = replayScript(opts, script, *dumpFrames)
+	} else {
+		result, err = finder.Run(context.Background(), opts)
 	}
 
-	finalM := finalMUncast.(model)
-	if !finalM.completedWithSelection {
-		os.Exit(NoSelectionExitCode)
+	// Closing a Unix socket listener also removes the socket file. Do this before any 'os.Exit' because deferred
+	// functions don't run on exit.
+	if opts.Listener != nil {
+		opts.Listener.Close()
 	}
 
-	if finalM.item < 0 {
-		os.Exit(NoMatchExitCode)
+	if errors.Is(err, finder.ErrAborted) {
+		fail(Failure{Code: "aborted", ExitCode: NoSelectionExitCode, Message: "Aborted without a selection"})
+	} else if err != nil {
+		runFailure.Message = fmt.Sprintf("Error running program: %v", err)
+		fail(runFailure)
 	}
 
-	selectedItem := ReturnItem{
-		Index: finalM.item,
-		Value: allItems[finalM.item],
+	// The value of each selected item is the structured value, if there is one. Tabular input is output as rows, not
+	// as the tab-joined match text.
+	var selection []ReturnItem
+	for _, s := range result.Selection {
+		item := ReturnItem{Index: s.Index, Indices: s.Indices, Value: s.Raw}
+		if s.Value != nil {
+			item.Value = *s.Value
+		}
+		selection = append(selection, item)
+	}
+	plainValue := func(s finder.Selection) string {
+		if s.Row == nil {
+			return s.Raw
+		}
+		var b strings.Builder
+		w := csv.NewWriter(&b)
+		if *tsvIn {
+			w.Comma = '\t'
+		}
+		w.Write(s.Row)
+		w.Flush()
+		return strings.TrimSuffix(b.String(), "\n")
 	}
 
-	if *jsonOut {
-		encoder := json.NewEncoder(os.Stdout)
-		if err := encoder.Encode(selectedItem); err != nil {
-			fmt.Fprintf(os.Stderr, "Error encoding JSON output: %v\n", err)
-			os.Exit(1)
+	// The accepting key and the query are only reported when they were asked for. This keeps the default output shape
+	// simple.
+	if *nuonOut {
+		// Like the JSON output, but the value of each selected item is the original structured value, if there is one.
+		selectionList := nuon.Value{Kind: nuon.List, List: []nuon.Value{}}
+		for _, s := range result.Selection {
+			value := nuon.NewString(s.Raw)
+			if s.Value != nil {
+				value = *s.Value
+			}
+			record := nuon.Value{Kind: nuon.Record, Record: []nuon.Field{
+				{Key: "index", Value: nuon.NewInt(s.Index)},
+			}}
+			if s.Indices != nil {
+				indices := nuon.Value{Kind: nuon.List, List: []nuon.Value{}}
+				for _, i := range s.Indices {
+					indices.List = append(indices.List, nuon.NewInt(i))
+				}
+				record.Record = append(record.Record, nuon.Field{Key: "indices", Value: indices})
+			}
+			record.Record = append(record.Record, nuon.Field{Key: "value", Value: value})
+			selectionList.List = append(selectionList.List, record)
+		}
+
+		var out *nuon.Value
+		if *expect != "" || *printQuery {
+			record := nuon.Value{Kind: nuon.Record}
+			if *expect != "" {
+				record.Record = append(record.Record, nuon.Field{Key: "key", Value: nuon.NewString(result.Key)})
+			}
+			if *printQuery {
+				record.Record = append(record.Record, nuon.Field{Key: "query", Value: nuon.NewString(result.Query)})
+			}
+			record.Record = append(record.Record, nuon.Field{Key: "selection", Value: selectionList})
+			out = &record
+		} else if len(selectionList.List) > 0 {
+			out = &selectionList.List[0]
+		}
+
+		if out != nil {
+			fmt.Println(nuon.Format(*out))
+		}
+	} else if *jsonOut {
+		var out any
+		if *expect != "" || *printQuery {
+			r := Result{Selection: selection}
+			if r.Selection == nil {
+				r.Selection = []ReturnItem{}
+			}
+			if *expect != "" {
+				r.Key = &result.Key
+			}
+			if *printQuery {
+				r.Query = &result.Query
+			}
+			out = r
+		} else if len(selection) > 0 {
+			out = selection[0]
+		}
+
+		if out != nil {
+			encoder := json.NewEncoder(os.Stdout)
+			if err := encoder.Encode(out); err != nil {
+				fail(Failure{Code: "system", ExitCode: SystemExitCode, Message: fmt.Sprintf("Error encoding JSON output: %v", err)})
+			}
 		}
 	} else {
-		fmt.Print(selectedItem.Value)
+		// Follow the fzf line-oriented output format: the query line, then the key line, then the selection.
+		if *printQuery {
+			fmt.Println(result.Query)
+		}
+		if *expect != "" {
+			fmt.Println(result.Key)
+		}
+		if len(result.Selection) > 0 {
+			fmt.Print(plainValue(result.Selection[0]))
+		}
+	}
+
+	// The selection is output even if it can't be remembered, so that a broken store doesn't break the caller.
+	if *frecencyKey != "" && len(result.Selection) > 0 {
+		if err := store.record(*frecencyKey, result.Selection[0].Text, time.Now()); err != nil {
+			fail(Failure{Code: "system", ExitCode: SystemExitCode, Message: fmt.Sprintf("Error saving the frecency store: %v", err)})
+		}
+	}
+
+	if len(result.Selection) == 0 {
+		fail(noMatch)
 	}
 }
 
-func Map[E, T any](items []E, f func(E, int) T) []T {
-	result := make([]T, len(items))
-	for i, item := range items {
-		result[i] = f(item, i)
+// Report a failure on w, as JSON with '--json-errors'. Without it, only actual errors are reported, and as text.
+func reportFailure(w io.Writer, jsonErrors bool, f Failure) {
+	if jsonErrors {
+		json.NewEncoder(w).Encode(f)
+	} else if f.Code != "no_match" && f.Code != "aborted" {
+		fmt.Fprintln(w, f.Message)
 	}
-	return result
 }
 
-// Similar to lipgloss.StyleRunes but adapted to work for multi-line text.
-func underlineMatches(str string, matchedPositions []int, style lipgloss.Style) string {
-	underlineStyle := lipgloss.NewStyle().Underline(true).Inherit(style)
+// Whether '--json-errors' is among the arguments, in any of the spellings that the flag package accepts. This is for
+// when parsing stopped at a bad flag before getting to it. Like the flag package, the last occurrence wins, and the
+// arguments after '--' aren't flags.
+func jsonErrorsArg(args []string) bool {
+	enabled := false
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "json-errors" {
+			continue
+		}
+		if !hasValue {
+			enabled = true
+		} else if b, err := strconv.ParseBool(value); err == nil {
+			enabled = b
+		}
+	}
+	return enabled
+}
+
+// The position of a decoding error in the input, for '--json-errors'. JSON and NUON syntax errors have a byte offset,
+// and CSV errors have a line and a column.
+func decodeErrorDetails(err error) map[string]any {
+	var jsonSyntaxErr *json.SyntaxError
+	var jsonTypeErr *json.UnmarshalTypeError
+	var nuonSyntaxErr *nuon.SyntaxError
+	var csvErr *csv.ParseError
+	switch {
+	case errors.As(err, &jsonSyntaxErr):
+		return map[string]any{"offset": jsonSyntaxErr.Offset}
+	case errors.As(err, &jsonTypeErr):
+		return map[string]any{"offset": jsonTypeErr.Offset, "expected": jsonTypeErr.Type.String(), "got": jsonTypeErr.Value}
+	case errors.As(err, &nuonSyntaxErr):
+		return map[string]any{"offset": nuonSyntaxErr.Offset}
+	case errors.As(err, &csvErr):
+		return map[string]any{"line": csvErr.Line, "column": csvErr.Column}
+	}
+	return nil
+}
+
+// The 'index' subcommand. 'index build <file>' builds an index of the lines of the standard input, or of the files in
+// the current directory if nothing is piped in, and writes it to the file (see 'fz.Index').
+func indexCommand(args []string) {
+	if len(args) != 2 || args[0] != "build" {
+		fmt.Fprintln(os.Stderr, "Usage: my-fuzzy-finder index build <file>")
+		os.Exit(UsageExitCode)
+	}
+
+	var b fz.IndexBuilder
+	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		paths := make(chan string, 1024)
+		go func() {
+			walkFiles(".", walkerOptions{files: true, follow: true}, paths)
+			close(paths)
+		}()
+		for path := range paths {
+			b.Add(path)
+		}
+	} else {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			b.Add(scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading standard input: %v\n", err)
+			os.Exit(InputExitCode)
+		}
+	}
+
+	f, err := os.Create(args[1])
+	if err == nil {
+		_, err = b.WriteTo(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the index: %v\n", err)
+		os.Exit(SystemExitCode)
+	}
+}
+
+// Replay a scripted session (see '--replay'), and write the frames to a directory if one is given.
+func replayScript(opts finder.Options, script finder.Script, framesDir string) (finder.Result, error) {
+	var frame func(string)
+	var frameErr error
+	if framesDir != "" {
+		if err := os.MkdirAll(framesDir, 0755); err != nil {
+			return finder.Result{}, err
+		}
+		n := 0
+		frame = func(view string) {
+			n++
+			if err := os.WriteFile(filepath.Join(framesDir, fmt.Sprintf("frame-%04d.txt", n)), []byte(view+"\n"), 0644); err != nil && frameErr == nil {
+				frameErr = err
+			}
+		}
+	}
+
+	result, err := finder.Replay(context.Background(), opts, script, frame)
+	if err == nil && frameErr != nil {
+		err = fmt.Errorf("writing the frames: %w", frameErr)
+	}
+	return result, err
+}
+
+// Batch the items that stream in so that the UI re-flows a few times per second instead of once per item.
+func batch(items <-chan string) <-chan []string {
+	batches := make(chan []string)
+	go func() {
+		defer close(batches)
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		var batch []string
+		for {
+			select {
+			case item, ok := <-items:
+				if !ok {
+					if len(batch) > 0 {
+						batches <- batch
+					}
+					return
+				}
+				batch = append(batch, item)
+			case <-ticker.C:
+				if len(batch) > 0 {
+					batches <- batch
+					batch = nil
+				}
+			}
+		}
+	}()
+	return batches
+}
 
-	// Convert slice of matched positions to a map for easier lookups
-	m := make(map[int]struct{})
-	for _, i := range matchedPositions {
-		m[i] = struct{}{}
+// Follow a file like 'tail -F' and send its lines to 'lines', starting with the lines that are already in the file. An
+// incomplete last line is held back until the rest of it is written. When the file is truncated, it's read again from
+// the start. When the path is another file, like after a log file was rotated, the rest of the old file is read and
+// then the new file is followed from its start.
+//
+// The file is watched for changes (see 'watchFile'). If it can't be watched, then it's polled instead.
+func followFile(path string, lines chan<- string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
 	}
 
-	noStyle := lipgloss.NewStyle()
+	changed, err := watchFile(path)
+	if err != nil {
+		log.Printf("Polling '%s' for changes because it can't be watched: %v\n", path, err)
+		c := make(chan struct{}, 1)
+		go pollFile(c, 250*time.Millisecond)
+		changed = c
+	}
 
+	go func() {
+		defer func() { f.Close() }()
+		reader := bufio.NewReader(f)
+		var (
+			partial string
+			offset  int64
+		)
+		readLines := func() {
+			for {
+				line, err := reader.ReadString('\n')
+				offset += int64(len(line))
+				if err != nil {
+					partial += line
+					return
+				}
+				lines <- strings.TrimSuffix(partial+line, "\n")
+				partial = ""
+			}
+		}
+		for {
+			readLines()
+
+			<-changed
+			info, err := f.Stat()
+			if err == nil && info.Size() < offset {
+				log.Printf("'%s' was truncated. Reading it again from the start.\n", path)
+				if _, err := f.Seek(0, io.SeekStart); err != nil {
+					log.Printf("Stopped following '%s': %v\n", path, err)
+					return
+				}
+				reader.Reset(f)
+				partial, offset = "", 0
+			}
+			if current, statErr := os.Stat(path); err == nil && statErr == nil && !os.SameFile(info, current) {
+				next, err := os.Open(path)
+				if err != nil {
+					continue
+				}
+				log.Printf("'%s' was replaced. Following the new file from the start.\n", path)
+				// Nothing more is written to the old file's last line once the writer has moved on.
+				readLines()
+				if partial != "" {
+					lines <- partial
+				}
+				f.Close()
+				f = next
+				reader.Reset(f)
+				partial, offset = "", 0
+			}
+		}
+	}()
+	return nil
+}
+
+// Signal a change every 'interval', for when a file can't be watched. Reading at the end of a file is cheap, so there's
+// no need to check if the file actually changed.
+func pollFile(changed chan<- struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+type walkerOptions struct {
+	files  bool
+	dirs   bool
+	hidden bool
+	follow bool
+}
+
+// ignoreRule is a pattern from a '.gitignore' file. The pattern is matched against paths relative to 'base', which is
+// the directory of the '.gitignore' file.
+type ignoreRule struct {
+	base     string
+	re       *regexp.Regexp
+	anchored bool // Match the relative path rather than only the name
+	negate   bool
+	dirOnly  bool
+}
+
+// Walk a directory tree and send the paths to 'paths'. Directories are read concurrently, by a bounded number of
+// goroutines. The '.git' directory and anything matched by '.gitignore' files is skipped.
+func walkFiles(root string, options walkerOptions, paths chan<- string) {
 	var (
-		out   strings.Builder
-		group strings.Builder
-		runes = []rune(str)
+		wg sync.WaitGroup
+		// A slot for each goroutine besides the calling one. When all are taken, a directory is walked by the goroutine
+		// that found it, so that a wide tree doesn't start a goroutine per directory.
+		sem     = make(chan struct{}, 2*runtime.NumCPU())
+		mu      sync.Mutex
+		visited = make(map[string]bool) // Real paths of followed links, to avoid cycles
 	)
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		if abs, err := filepath.Abs(real); err == nil {
+			visited[abs] = true
+		}
+	}
+
+	var visit func(dir string, rules []ignoreRule)
+	visit = func(dir string, rules []ignoreRule) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			log.Printf("Skipping directory '%s': %v\n", dir, err)
+			return
+		}
+
+		if data, err := os.ReadFile(filepath.Join(dir, ".gitignore")); err == nil {
+			// Clip so that sibling directories don't share appends.
+			rules = append(slices.Clip(rules), parseGitignore(dir, string(data))...)
+		}
 
-	for i, r := range runes {
-		if r == '\n' {
-			out.WriteString(noStyle.Render("\n"))
+		for _, entry := range entries {
+			name := entry.Name()
+			if name == ".git" || (!options.hidden && strings.HasPrefix(name, ".")) {
+				continue
+			}
+
+			path := filepath.Join(dir, name)
+			isDir := entry.IsDir()
+			// Without 'follow', a link is listed like a file even if it links to a directory.
+			if entry.Type()&os.ModeSymlink != 0 && options.follow {
+				info, err := os.Stat(path)
+				if err != nil {
+					continue
+				}
+				isDir = info.IsDir()
+				if isDir {
+					real, err := filepath.EvalSymlinks(path)
+					if err != nil {
+						continue
+					}
+					real, _ = filepath.Abs(real)
+					mu.Lock()
+					seen := visited[real]
+					visited[real] = true
+					mu.Unlock()
+					if seen {
+						continue
+					}
+				}
+			}
+
+			if ignored(rules, path, isDir) {
+				continue
+			}
+
+			if isDir {
+				if options.dirs {
+					paths <- path
+				}
+				select {
+				case sem <- struct{}{}:
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						visit(path, rules)
+						<-sem
+					}()
+				default:
+					visit(path, rules)
+				}
+			} else if options.files {
+				paths <- path
+			}
+		}
+	}
+
+	visit(root, nil)
+	wg.Wait()
+}
+
+// Parse the patterns of a '.gitignore' file. See https://git-scm.com/docs/gitignore
+func parseGitignore(base string, content string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, " \r")
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
 
-		group.WriteRune(r)
+		rule := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\") {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		// A pattern with a slash at the beginning or in the middle is relative to the '.gitignore' file. Otherwise, it
+		// matches a name at any depth.
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
 
-		_, matches := m[i]
-		_, nextMatches := m[i+1]
+		re, err := regexp.Compile("^" + globToRegexp(line) + "$")
+		if err != nil {
+			log.Printf("Skipping invalid .gitignore pattern '%s' in '%s': %v\n", line, base, err)
+			continue
+		}
+		rule.re = re
+		rules = append(rules, rule)
+	}
+	return rules
+}
 
-		if matches != nextMatches || i == len(runes)-1 || runes[i+1] == '\n' {
-			s := group.String()
-			if matches {
-				s = underlineStyle.Render(s)
-			} else {
-				s = style.Render(s)
+// Convert a gitignore glob to a regular expression.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			b.WriteString("[^/]*")
+		case glob[i] == '?':
+			b.WriteString("[^/]")
+		case glob[i] == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == -1 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
 			}
-			out.WriteString(s)
-			group.Reset()
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case glob[i] == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
 		}
 	}
+	return b.String()
+}
 
-	return out.String()
+// Whether a path is ignored by the rules. A rule only applies below its base, and the last matching rule wins, so a
+// negated rule can un-ignore a path.
+func ignored(rules []ignoreRule, path string, isDir bool) bool {
+	result := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(rule.base, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if !rule.anchored {
+			rel = filepath.Base(rel)
+		}
+		if rule.re.MatchString(rel) {
+			result = !rule.negate
+		}
+	}
+	return result
 }
 
-func MatchAll(query string, items []string) []Match {
-	pattern := fz.BuildPattern(query)
-	var matches []Match
+// The maximum number of items to remember per frecency key. The lowest scoring items are forgotten first.
+const frecencyStoreSize = 1000
+
+// frecencyStore remembers how often and how recently items were selected, for one frecency key. It's stored as a JSON
+// file in the XDG state directory (by default '~/.local/state/my-fuzzy-finder/frecency/<key>.json').
+type frecencyStore struct {
+	Entries map[string]frecencyEntry `json:"entries"`
+}
+
+type frecencyEntry struct {
+	Count int   `json:"count"`
+	Last  int64 `json:"last"` // Unix seconds
+}
+
+// The frecency score is the selection count weighted by how recently the item was last selected. This is the same
+// scheme as zoxide (https://github.com/ajeetdsouza/zoxide).
+func (e frecencyEntry) score(now time.Time) float64 {
+	age := now.Sub(time.Unix(e.Last, 0))
+	switch {
+	case age < time.Hour:
+		return float64(e.Count) * 4
+	case age < 24*time.Hour:
+		return float64(e.Count) * 2
+	case age < 7*24*time.Hour:
+		return float64(e.Count) / 2
+	default:
+		return float64(e.Count) / 4
+	}
+}
 
-	for i, item := range items {
-		if ok, positions := pattern.MatchItem(item); ok {
-			matches = append(matches, Match{
-				Index:     i,
-				Positions: positions,
-			})
+func frecencyStorePath(key string) (string, error) {
+	if key == "" || strings.Trim(key, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789._-") != "" || strings.HasPrefix(key, ".") {
+		return "", fmt.Errorf("the frecency key '%s' must only have letters, digits, '.', '_' and '-'", key)
+	}
+
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
 		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "my-fuzzy-finder", "frecency", key+".json"), nil
+}
+
+func loadFrecencyStore(key string) (frecencyStore, error) {
+	store := frecencyStore{Entries: map[string]frecencyEntry{}}
+	path, err := frecencyStorePath(key)
+	if err != nil {
+		return store, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return store, err
+	}
+	if err := json.Unmarshal(data, &store); err != nil {
+		return store, fmt.Errorf("%s is corrupt: %w", path, err)
+	}
+	if store.Entries == nil {
+		store.Entries = map[string]frecencyEntry{}
 	}
-	return matches
+	return store, nil
 }
 
-type Match struct {
-	Index     int
-	Positions []int
+// Record a selection and save the store. The file is replaced atomically so that concurrent finders don't see a
+// partially written file.
+func (store frecencyStore) record(key string, item string, now time.Time) error {
+	entry := store.Entries[item]
+	entry.Count++
+	entry.Last = now.Unix()
+	store.Entries[item] = entry
+
+	if len(store.Entries) > frecencyStoreSize {
+		var items []string
+		for item := range store.Entries {
+			items = append(items, item)
+		}
+		slices.SortFunc(items, func(a, b string) int {
+			return cmp.Compare(store.Entries[a].score(now), store.Entries[b].score(now))
+		})
+		for _, item := range items[:len(items)-frecencyStoreSize] {
+			delete(store.Entries, item)
+		}
+	}
+
+	path, err := frecencyStorePath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(store)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJsonErrorsArg(t *testing.T) {
+	tests := map[string]struct {
+		args     []string
+		expected bool
+	}{
+		"Absent":             {args: []string{"--bogus", "--json"}, expected: false},
+		"Double dash":        {args: []string{"--bogus", "--json-errors"}, expected: true},
+		"Single dash":        {args: []string{"-json-errors", "--bogus"}, expected: true},
+		"True value":         {args: []string{"--bogus", "--json-errors=true"}, expected: true},
+		"Single dash value":  {args: []string{"--bogus", "-json-errors=1"}, expected: true},
+		"False value":        {args: []string{"--bogus", "--json-errors=false"}, expected: false},
+		"Last one wins":      {args: []string{"--json-errors", "--bogus", "--json-errors=false"}, expected: false},
+		"Bad value":          {args: []string{"--bogus", "--json-errors=maybe"}, expected: false},
+		"Other flag":         {args: []string{"--bogus", "--json-errors-x"}, expected: false},
+		"Not a flag":         {args: []string{"--bogus", "json-errors"}, expected: false},
+		"After the flags":    {args: []string{"--bogus", "--", "--json-errors"}, expected: false},
+		"Triple dash":        {args: []string{"--bogus", "---json-errors"}, expected: false},
+		"Value of a flag":    {args: []string{"--query=--json-errors", "--bogus"}, expected: false},
+		"Before the bad one": {args: []string{"--json-errors=true", "--bogus"}, expected: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if actual := jsonErrorsArg(test.args); actual != test.expected {
+				t.Errorf("jsonErrorsArg(%q) = %v, want %v", test.args, actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestReportFailure(t *testing.T) {
+	// A frecency store that can't be saved because its directory would be inside a file.
+	saveFailure := func(t *testing.T) Failure {
+		dir := t.TempDir()
+		file := filepath.Join(dir, "file")
+		if err := os.WriteFile(file, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("XDG_STATE_HOME", file)
+		store := frecencyStore{Entries: map[string]frecencyEntry{}}
+		err := store.record("key", "item", time.Now())
+		if err == nil {
+			t.Fatal("record() succeeded, want an error")
+		}
+		return Failure{Code: "system", ExitCode: SystemExitCode, Message: "Error saving the frecency store: " + err.Error()}
+	}
+
+	tests := map[string]struct {
+		failure    func(t *testing.T) Failure
+		jsonErrors bool
+		// The expected failure code on stderr, or "text" for a text message, or "" for nothing.
+		expected string
+	}{
+		"Frecency save error as JSON": {failure: saveFailure, jsonErrors: true, expected: "system"},
+		"Frecency save error as text": {failure: saveFailure, expected: "text"},
+		"No match as JSON": {
+			failure: func(t *testing.T) Failure {
+				return Failure{Code: "no_match", ExitCode: NoMatchExitCode, Message: "No item matched"}
+			},
+			jsonErrors: true,
+			expected:   "no_match",
+		},
+		"No match without JSON": {
+			failure: func(t *testing.T) Failure {
+				return Failure{Code: "no_match", ExitCode: NoMatchExitCode, Message: "No item matched"}
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			f := test.failure(t)
+			var stderr bytes.Buffer
+			reportFailure(&stderr, test.jsonErrors, f)
+
+			switch test.expected {
+			case "":
+				if stderr.Len() != 0 {
+					t.Errorf("reportFailure() wrote %q, want nothing", stderr.String())
+				}
+			case "text":
+				if stderr.String() != f.Message+"\n" {
+					t.Errorf("reportFailure() wrote %q, want %q", stderr.String(), f.Message+"\n")
+				}
+			default:
+				var actual Failure
+				if err := json.Unmarshal(stderr.Bytes(), &actual); err != nil {
+					t.Fatalf("reportFailure() wrote %q, which isn't JSON: %v", stderr.String(), err)
+				}
+				if actual.Code != test.expected || actual.ExitCode != f.ExitCode || actual.Message != f.Message {
+					t.Errorf("reportFailure() wrote %+v, want %+v", actual, f)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := map[string]struct {
+		glob       string
+		matches    []string
+		nonMatches []string
+	}{
+		"Literal":             {glob: "a.txt", matches: []string{"a.txt"}, nonMatches: []string{"abtxt", "a.txt2"}},
+		"Star":                {glob: "*.log", matches: []string{".log", "a.log"}, nonMatches: []string{"a/b.log", "a.logs"}},
+		"Question mark":       {glob: "a?c", matches: []string{"abc"}, nonMatches: []string{"ac", "a/c"}},
+		"Class":               {glob: "[ab]x", matches: []string{"ax", "bx"}, nonMatches: []string{"cx"}},
+		"Negated class":       {glob: "[!ab]x", matches: []string{"cx"}, nonMatches: []string{"ax"}},
+		"Unclosed class":      {glob: "[ab", matches: []string{"[ab"}, nonMatches: []string{"a"}},
+		"Escape":              {glob: `\*`, matches: []string{"*"}, nonMatches: []string{"a"}},
+		"Leading double star": {glob: "**/b", matches: []string{"b", "a/b", "a/c/b"}, nonMatches: []string{"ab", "b/c"}},
+		"Trailing double star": {
+			glob:       "a/**",
+			matches:    []string{"a/b", "a/b/c"},
+			nonMatches: []string{"a", "b/a/c"},
+		},
+		"Middle double star": {glob: "a/**/b", matches: []string{"a/b", "a/x/b", "a/x/y/b"}, nonMatches: []string{"a/xb", "b"}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			for _, path := range test.matches {
+				if !regexpMatches(t, test.glob, path) {
+					t.Errorf("%q doesn't match %q, want a match", test.glob, path)
+				}
+			}
+			for _, path := range test.nonMatches {
+				if regexpMatches(t, test.glob, path) {
+					t.Errorf("%q matches %q, want no match", test.glob, path)
+				}
+			}
+		})
+	}
+}
+
+func regexpMatches(t *testing.T, glob string, path string) bool {
+	t.Helper()
+	rules := parseGitignore("", "/"+glob)
+	if len(rules) != 1 {
+		t.Fatalf("parseGitignore(%q) = %d rules, want 1", glob, len(rules))
+	}
+	return rules[0].re.MatchString(path)
+}
+
+func TestParseGitignore(t *testing.T) {
+	// A rule with its pattern as a string.
+	type rule struct {
+		pattern                   string
+		anchored, negate, dirOnly bool
+	}
+	tests := map[string]struct {
+		content  string
+		expected []rule
+	}{
+		"Comments and blank lines": {content: "# comment\n\n  \n", expected: nil},
+		"Unanchored":               {content: "a.txt", expected: []rule{{pattern: `^a\.txt$`}}},
+		"Leading slash":            {content: "/a", expected: []rule{{pattern: "^a$", anchored: true}}},
+		"Middle slash":             {content: "a/b", expected: []rule{{pattern: "^a/b$", anchored: true}}},
+		"Dir only":                 {content: "build/", expected: []rule{{pattern: "^build$", dirOnly: true}}},
+		"Anchored dir only":        {content: "a/build/", expected: []rule{{pattern: "^a/build$", anchored: true, dirOnly: true}}},
+		"Negation":                 {content: "!keep", expected: []rule{{pattern: "^keep$", negate: true}}},
+		"Escaped exclamation mark": {content: `\!keep`, expected: []rule{{pattern: "^!keep$"}}},
+		"Escaped hash":             {content: `\#a`, expected: []rule{{pattern: "^#a$"}}},
+		"Trailing spaces and CR":   {content: "a  \r\nb", expected: []rule{{pattern: "^a$"}, {pattern: "^b$"}}},
+		"Double star":              {content: "**/a", expected: []rule{{pattern: "^(?:.*/)?a$", anchored: true}}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var actual []rule
+			for _, r := range parseGitignore("base", test.content) {
+				if r.base != "base" {
+					t.Errorf("parseGitignore(%q) has the base %q, want %q", test.content, r.base, "base")
+				}
+				actual = append(actual, rule{pattern: r.re.String(), anchored: r.anchored, negate: r.negate, dirOnly: r.dirOnly})
+			}
+			if !slices.Equal(actual, test.expected) {
+				t.Errorf("parseGitignore(%q) = %+v, want %+v", test.content, actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	base := filepath.Join("repo", "sub")
+	tests := map[string]struct {
+		gitignore string
+		path      string // Relative to 'base'
+		isDir     bool
+		expected  bool
+	}{
+		"Unanchored name at the top":      {gitignore: "*.log", path: "a.log", expected: true},
+		"Unanchored name deeper":          {gitignore: "*.log", path: "x/y/a.log", expected: true},
+		"Unanchored name mismatch":        {gitignore: "*.log", path: "a.txt", expected: false},
+		"Anchored at the top":             {gitignore: "/build", path: "build", isDir: true, expected: true},
+		"Anchored deeper":                 {gitignore: "/build", path: "x/build", isDir: true, expected: false},
+		"Anchored with a middle slash":    {gitignore: "x/build", path: "x/build", isDir: true, expected: true},
+		"Middle slash isn't unanchored":   {gitignore: "x/build", path: "y/x/build", isDir: true, expected: false},
+		"Dir only on a directory":         {gitignore: "out/", path: "x/out", isDir: true, expected: true},
+		"Dir only on a file":              {gitignore: "out/", path: "x/out", expected: false},
+		"Negation":                        {gitignore: "*.log\n!keep.log", path: "keep.log", expected: false},
+		"Negation of others":              {gitignore: "*.log\n!keep.log", path: "other.log", expected: true},
+		"Negation before the rule":        {gitignore: "!keep.log\n*.log", path: "keep.log", expected: true},
+		"Leading double star":             {gitignore: "**/cache", path: "x/y/cache", isDir: true, expected: true},
+		"Middle double star":              {gitignore: "docs/**/*.md", path: "docs/a/b/c.md", expected: true},
+		"Middle double star other dir":    {gitignore: "docs/**/*.md", path: "src/docs/c.md", expected: false},
+		"Trailing double star":            {gitignore: "vendor/**", path: "vendor/a/b", expected: true},
+		"Trailing double star on the dir": {gitignore: "vendor/**", path: "vendor", isDir: true, expected: false},
+		"Outside the base":                {gitignore: "*.log", path: "../a.log", expected: false},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			rules := parseGitignore(base, test.gitignore)
+			path := filepath.Join(base, filepath.FromSlash(test.path))
+			if actual := ignored(rules, path, test.isDir); actual != test.expected {
+				t.Errorf("ignored(%q, %q, %v) = %v, want %v", test.gitignore, test.path, test.isDir, actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestWalkFiles(t *testing.T) {
+	// A wide and deep tree, so that there are more directories than the walker has goroutines.
+	var wide []string
+	for i := range 100 {
+		wide = append(wide, fmt.Sprintf("d%02d/%sf", i, strings.Repeat("x/", i%10)))
+	}
+
+	tests := map[string]struct {
+		files    []string // Paths of files to create, with '/' for directories
+		options  walkerOptions
+		expected []string // Relative paths
+	}{
+		"Files": {
+			files:    []string{"a", "b/c", "b/d/e"},
+			options:  walkerOptions{files: true},
+			expected: []string{"a", "b/c", "b/d/e"},
+		},
+		"Directories": {
+			files:    []string{"a", "b/c", "b/d/e"},
+			options:  walkerOptions{dirs: true},
+			expected: []string{"b", "b/d"},
+		},
+		"Hidden": {
+			files:    []string{".a", ".b/c", "d", ".git/config"},
+			options:  walkerOptions{files: true},
+			expected: []string{"d"},
+		},
+		"Hidden included": {
+			files:    []string{".a", ".b/c", "d", ".git/config"},
+			options:  walkerOptions{files: true, hidden: true},
+			expected: []string{".a", ".b/c", "d"},
+		},
+		"Gitignore": {
+			files: []string{
+				".gitignore=*.log\nbuild/\n/top\n!keep.log",
+				"a.log", "keep.log", "top", "x/top", "build/a", "x/build/b", "x/build.txt",
+				"sub/.gitignore=*.txt\n!x.log", "sub/a.txt", "sub/x.log", "sub/y.log",
+			},
+			options:  walkerOptions{files: true},
+			expected: []string{"keep.log", "sub/x.log", "x/build.txt", "x/top"},
+		},
+		"Gitignore is per directory": {
+			files:    []string{"a/.gitignore=f", "a/f", "b/f"},
+			options:  walkerOptions{files: true},
+			expected: []string{"b/f"},
+		},
+		"Wide and deep": {
+			files:    wide,
+			options:  walkerOptions{files: true},
+			expected: wide,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, file := range test.files {
+				file, content, _ := strings.Cut(file, "=")
+				path := filepath.Join(root, filepath.FromSlash(file))
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			paths := make(chan string)
+			go func() {
+				walkFiles(root, test.options, paths)
+				close(paths)
+			}()
+			var actual []string
+			for path := range paths {
+				rel, err := filepath.Rel(root, path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				actual = append(actual, filepath.ToSlash(rel))
+			}
+
+			slices.Sort(actual)
+			expected := slices.Clone(test.expected)
+			slices.Sort(expected)
+			if !slices.Equal(actual, expected) {
+				t.Errorf("walkFiles() = %q, want %q", actual, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+//go:build linux
+
+package main
+
+import (
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Watch a file for changes with inotify. A value is sent on the channel after the file changes. Changes that happen in
+// quick succession may be coalesced into one. If reading the events fails, the file is polled instead.
+//
+// When the file is moved or deleted, like when a log file is rotated, the path is watched again once it exists again,
+// like with 'tail -F'. Until then, the changes are polled for, because the old file may still be written to.
+func watchFile(path string) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	const mask = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_MOVE_SELF | syscall.IN_DELETE_SELF
+	watched, err := os.Stat(path)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	wd, err := syscall.InotifyAddWatch(fd, path, mask)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	changed := make(chan struct{}, 1)
+	signal := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	go func() {
+		// The events themselves don't matter. Any event means that there may be something new to read, or that the path
+		// may be another file now.
+		buf := make([]byte, 4096)
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				log.Printf("Polling '%s' for changes because reading the inotify events failed: %v\n", path, err)
+				syscall.Close(fd)
+				pollFile(changed, 250*time.Millisecond)
+				return
+			}
+			if n > 0 {
+				signal()
+			}
+
+			if info, err := os.Stat(path); err == nil && os.SameFile(info, watched) {
+				continue
+			}
+			// The watch of a deleted file is already gone, so an error here doesn't matter.
+			syscall.InotifyRmWatch(fd, uint32(wd))
+			for {
+				time.Sleep(250 * time.Millisecond)
+				signal()
+				if watched, err = os.Stat(path); err != nil {
+					continue
+				}
+				if wd, err = syscall.InotifyAddWatch(fd, path, mask); err == nil {
+					break
+				}
+			}
+			log.Printf("Watching '%s' again because it was replaced.\n", path)
+			signal()
+		}
+	}()
+	return changed, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// Watching files is only implemented with inotify, on Linux. Elsewhere, files are polled instead (see 'followFile').
+func watchFile(path string) (<-chan struct{}, error) {
+	return nil, errors.New("watching files is only supported on Linux")
+}
@@ -0,0 +1,635 @@
+// Package nuon parses and formats NUON, the "Nushell Object Notation" (https://www.nushell.sh/book/loading_data.html#nuon).
+//
+// NUON is a superset of JSON that is written by Nushell's 'to nuon' command. It has Nushell-specific types like
+// durations (e.g. '3day') and file sizes (e.g. '1.5kB'), bare word strings, optional commas, and a compact table syntax
+// (e.g. '[[name, size]; [a, 1kB], [b, 2kB]]').
+//
+// The design goal is a lossless round trip for the data I pipe through my tools. Scalars that have a Nushell-specific
+// literal form (numbers, durations, file sizes, dates, booleans and null) keep their original literal text, and they are
+// formatted back out exactly as they came in. Records keep their key order.
+//
+// Not supported: binary literals ('0x[...]'), ranges, closures, and raw strings ('r#'...'#').
+package nuon
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+type Kind int
+
+const (
+	Null Kind = iota
+	Bool
+	Int
+	Float
+	String
+	Duration
+	Filesize
+	Date
+	List
+	Record
+)
+
+// Value is a NUON value.
+type Value struct {
+	Kind Kind
+
+	// The literal text of scalars other than strings (e.g. "1.5kB", "3day", "true"). This is what gets formatted.
+	Raw string
+
+	// The content of a string.
+	Str string
+
+	// The numeric value of numbers, durations (in nanoseconds) and file sizes (in bytes).
+	Number float64
+
+	List   []Value
+	Record []Field
+}
+
+// Field is a key and value pair of a record.
+type Field struct {
+	Key   string
+	Value Value
+}
+
+// Get returns the value of the record field with the given key.
+func (v Value) Get(key string) (Value, bool) {
+	for _, f := range v.Record {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return Value{}, false
+}
+
+// Text returns a human-readable text form of the value. Strings are returned as-is (not quoted) and everything else is
+// formatted as NUON.
+func (v Value) Text() string {
+	if v.Kind == String {
+		return v.Str
+	}
+	return Format(v)
+}
+
+// NewString creates a string value.
+func NewString(s string) Value {
+	return Value{Kind: String, Str: s}
+}
+
+// NewInt creates an int value.
+func NewInt(i int) Value {
+	return Value{Kind: Int, Raw: strconv.Itoa(i), Number: float64(i)}
+}
+
+// Units in nanoseconds.
+var durationUnits = map[string]float64{
+	"ns":  1,
+	"us":  1e3,
+	"µs":  1e3,
+	"ms":  1e6,
+	"sec": 1e9,
+	"min": 60e9,
+	"hr":  3600e9,
+	"day": 86400e9,
+	"wk":  604800e9,
+}
+
+// Units in bytes. Nushell matches file size units case-insensitively.
+var filesizeUnits = map[string]float64{
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"pb":  1e15,
+	"eb":  1e18,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+	"eib": 1 << 60,
+}
+
+var numberWithUnit = regexp.MustCompile(`^(-?(?:\d[\d_]*)?(?:\.\d+)?(?:[eE][-+]?\d+)?)([a-zA-Zµ]+)$`)
+var datePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}([T ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?)?(Z|[-+]\d{2}:\d{2})?$`)
+
+// ParseQuantity parses a bare number, duration or file size literal (e.g. "10", "1.5kB", "3day") and returns its kind
+// and numeric value in base units.
+func ParseQuantity(s string) (Kind, float64, bool) {
+	switch s {
+	case "inf", "+inf":
+		return Float, math.Inf(1), true
+	case "-inf":
+		return Float, math.Inf(-1), true
+	case "NaN":
+		return Float, math.NaN(), true
+	}
+
+	// Go's number parsing is more lenient than Nushell's (e.g. "Infinity" is a float), so only try it on things that
+	// look like numbers.
+	if s == "" || !strings.ContainsRune("0123456789-+.", rune(s[0])) {
+		return 0, 0, false
+	}
+	// Ints are decimal, even with leading zeros, unless they have a '0x', '0o' or '0b' prefix.
+	digits, base := strings.ReplaceAll(s, "_", ""), 10
+	sign, unsigned := "", digits
+	if unsigned != "" && (unsigned[0] == '-' || unsigned[0] == '+') {
+		sign, unsigned = unsigned[:1], unsigned[1:]
+	}
+	if len(unsigned) > 2 && unsigned[0] == '0' {
+		switch unsigned[1] {
+		case 'x':
+			digits, base = sign+unsigned[2:], 16
+		case 'o':
+			digits, base = sign+unsigned[2:], 8
+		case 'b':
+			digits, base = sign+unsigned[2:], 2
+		}
+	}
+	if i, err := strconv.ParseInt(digits, base, 64); err == nil {
+		return Int, float64(i), true
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return Float, f, true
+	}
+
+	groups := numberWithUnit.FindStringSubmatch(s)
+	if groups == nil || groups[1] == "" || groups[1] == "-" {
+		return 0, 0, false
+	}
+	n, err := strconv.ParseFloat(strings.ReplaceAll(groups[1], "_", ""), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if unit, ok := durationUnits[groups[2]]; ok {
+		return Duration, n * unit, true
+	}
+	if unit, ok := filesizeUnits[strings.ToLower(groups[2])]; ok {
+		return Filesize, n * unit, true
+	}
+	return 0, 0, false
+}
+
+// The layouts of the dates that 'datePattern' matches, with a 'T' between the date and the time.
+var dateLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02Z07:00",
+	"2006-01-02",
+}
+
+// ParseDate parses a date literal (e.g. "2024-01-31" or "2024-01-31T12:00:00+01:00"). A date without a time zone is in
+// the local time zone.
+func ParseDate(s string) (time.Time, bool) {
+	s = strings.ToUpper(s)
+	if !datePattern.MatchString(s) {
+		return time.Time{}, false
+	}
+	if len(s) > 10 && s[10] == ' ' {
+		s = s[:10] + "T" + s[11:]
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Parse parses a single NUON value. Leading and trailing whitespace and comments are allowed.
+func Parse(s string) (Value, error) {
+	p := parser{src: s}
+	p.skipSpace()
+	v, err := p.value()
+	if err != nil {
+		return Value{}, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return Value{}, p.errorf("unexpected trailing content")
+	}
+	return v, nil
+}
+
+// SyntaxError describes a NUON syntax error and its byte offset in the input.
+type SyntaxError struct {
+	Offset int
+	msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s (at offset %d)", e.msg, e.Offset)
+}
+
+type parser struct {
+	src string
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &SyntaxError{Offset: p.pos, msg: fmt.Sprintf(format, args...)}
+}
+
+// Skip whitespace, commas and comments. In NUON, commas are optional separators.
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) value() (Value, error) {
+	switch p.peek() {
+	case 0:
+		return Value{}, p.errorf("unexpected end of input")
+	case '[':
+		return p.list()
+	case '{':
+		return p.record()
+	case '"', '\'', '`':
+		s, err := p.quoted()
+		if err != nil {
+			return Value{}, err
+		}
+		return NewString(s), nil
+	default:
+		word := p.bare(false)
+		if word == "" {
+			return Value{}, p.errorf("unexpected character %q", p.peek())
+		}
+		return classify(word), nil
+	}
+}
+
+// Classify a bare word as a scalar literal, falling back to a string.
+func classify(word string) Value {
+	switch word {
+	case "null":
+		return Value{Kind: Null, Raw: word}
+	case "true", "false":
+		return Value{Kind: Bool, Raw: word}
+	}
+	if kind, n, ok := ParseQuantity(word); ok {
+		return Value{Kind: kind, Raw: word, Number: n}
+	}
+	if datePattern.MatchString(word) {
+		return Value{Kind: Date, Raw: word}
+	}
+	return NewString(word)
+}
+
+// Read a bare word. In key position, a colon ends the word. Elsewhere it doesn't, because dates like
+// '2024-07-01T10:00:00-05:00' have colons.
+func (p *parser) bare(key bool) string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' || c == '[' || c == ']' || c == '{' || c == '}' || c == '"' || c == '\'' || c == '`' || c == '#' || (key && c == ':') {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// Read a double-quoted string (with escapes), a single-quoted string or a backtick-quoted string (both raw).
+func (p *parser) quoted() (string, error) {
+	start := p.pos
+	quote := p.src[p.pos]
+	p.pos++
+	if quote != '"' {
+		end := strings.IndexByte(p.src[p.pos:], quote)
+		if end == -1 {
+			p.pos = start
+			return "", p.errorf("unterminated string")
+		}
+		s := p.src[p.pos : p.pos+end]
+		p.pos += end + 1
+		return s, nil
+	}
+
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch c {
+		case '"':
+			p.pos++
+			return b.String(), nil
+		case '\\':
+			p.pos++
+			if p.pos >= len(p.src) {
+				break
+			}
+			e := p.src[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case '"', '\\', '/', '\'':
+				b.WriteByte(e)
+			case 'u':
+				// Both the JSON form '\u00e9' and the Nushell form '\u{e9}' are accepted.
+				var hex string
+				if p.peek() == '{' {
+					end := strings.IndexByte(p.src[p.pos:], '}')
+					if end == -1 {
+						return "", p.errorf("unterminated unicode escape")
+					}
+					hex = p.src[p.pos+1 : p.pos+end]
+					p.pos += end + 1
+				} else if p.pos+4 <= len(p.src) {
+					hex = p.src[p.pos : p.pos+4]
+					p.pos += 4
+				}
+				r, err := strconv.ParseUint(hex, 16, 32)
+				if err != nil {
+					return "", p.errorf("invalid unicode escape '%s'", hex)
+				}
+				// JSON escapes characters outside of the Basic Multilingual Plane (e.g. emoji) as a UTF-16 surrogate
+				// pair, like '\ud83d\ude00'. A lone surrogate becomes U+FFFD, like with 'encoding/json'.
+				if utf16.IsSurrogate(rune(r)) && strings.HasPrefix(p.src[p.pos:], "\\u") && p.pos+6 <= len(p.src) {
+					if low, err := strconv.ParseUint(p.src[p.pos+2:p.pos+6], 16, 32); err == nil {
+						if pair := utf16.DecodeRune(rune(r), rune(low)); pair != utf8.RuneError {
+							r = uint64(pair)
+							p.pos += 6
+						}
+					}
+				}
+				b.WriteRune(rune(r))
+			default:
+				return "", p.errorf("invalid escape '\\%c'", e)
+			}
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	p.pos = start
+	return "", p.errorf("unterminated string")
+}
+
+func (p *parser) list() (Value, error) {
+	p.pos++ // '['
+	list := Value{Kind: List}
+	for {
+		p.skipSpace()
+		switch p.peek() {
+		case 0:
+			return Value{}, p.errorf("unterminated list")
+		case ']':
+			p.pos++
+			return list, nil
+		case ';':
+			// This is the table syntax. The one list so far is the header row, and the rest are data rows.
+			if len(list.List) != 1 || list.List[0].Kind != List {
+				return Value{}, p.errorf("unexpected ';'")
+			}
+			p.pos++
+			return p.tableRows(list.List[0].List)
+		}
+
+		v, err := p.value()
+		if err != nil {
+			return Value{}, err
+		}
+		list.List = append(list.List, v)
+	}
+}
+
+func (p *parser) tableRows(header []Value) (Value, error) {
+	table := Value{Kind: List, List: []Value{}}
+	for {
+		p.skipSpace()
+		switch p.peek() {
+		case 0:
+			return Value{}, p.errorf("unterminated table")
+		case ']':
+			p.pos++
+			return table, nil
+		case '[':
+		default:
+			return Value{}, p.errorf("expected a table row")
+		}
+
+		start := p.pos
+		row, err := p.list()
+		if err != nil {
+			return Value{}, err
+		}
+		if len(row.List) != len(header) {
+			p.pos = start
+			return Value{}, p.errorf("expected %d columns but found %d", len(header), len(row.List))
+		}
+		record := Value{Kind: Record, Record: []Field{}}
+		for i, column := range header {
+			record.Record = append(record.Record, Field{Key: column.Text(), Value: row.List[i]})
+		}
+		table.List = append(table.List, record)
+	}
+}
+
+func (p *parser) record() (Value, error) {
+	p.pos++ // '{'
+	record := Value{Kind: Record, Record: []Field{}}
+	for {
+		p.skipSpace()
+		var key string
+		switch p.peek() {
+		case 0:
+			return Value{}, p.errorf("unterminated record")
+		case '}':
+			p.pos++
+			return record, nil
+		case '"', '\'', '`':
+			var err error
+			if key, err = p.quoted(); err != nil {
+				return Value{}, err
+			}
+		default:
+			if key = p.bare(true); key == "" {
+				return Value{}, p.errorf("expected a record key")
+			}
+		}
+
+		for p.peek() == ' ' || p.peek() == '\t' {
+			p.pos++
+		}
+		if p.peek() != ':' {
+			return Value{}, p.errorf("expected ':' after record key '%s'", key)
+		}
+		p.pos++
+		p.skipSpace()
+
+		v, err := p.value()
+		if err != nil {
+			return Value{}, err
+		}
+		record.Record = append(record.Record, Field{Key: key, Value: v})
+	}
+}
+
+// Format formats a value as compact, single-line NUON.
+func Format(v Value) string {
+	var b strings.Builder
+	format(&b, v)
+	return b.String()
+}
+
+func format(b *strings.Builder, v Value) {
+	switch v.Kind {
+	case String:
+		formatString(b, v.Str)
+	case List:
+		b.WriteByte('[')
+		for i, item := range v.List {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			format(b, item)
+		}
+		b.WriteByte(']')
+	case Record:
+		b.WriteByte('{')
+		for i, f := range v.Record {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if isBareKey(f.Key) {
+				b.WriteString(f.Key)
+			} else {
+				formatString(b, f.Key)
+			}
+			b.WriteString(": ")
+			format(b, f.Value)
+		}
+		b.WriteByte('}')
+	default:
+		if v.Raw == "" {
+			b.WriteString("null")
+		} else {
+			b.WriteString(v.Raw)
+		}
+	}
+}
+
+// MarshalJSON encodes the value as JSON the way Nushell's 'to json' does: durations become nanoseconds, file sizes
+// become bytes and dates become strings. Numbers that were JSON to begin with keep their original literal text.
+func (v Value) MarshalJSON() ([]byte, error) {
+	switch v.Kind {
+	case String:
+		return json.Marshal(v.Str)
+	case Date:
+		return json.Marshal(v.Raw)
+	case Int, Float:
+		if json.Valid([]byte(v.Raw)) {
+			return []byte(v.Raw), nil
+		}
+		if math.IsInf(v.Number, 0) || math.IsNaN(v.Number) {
+			return []byte("null"), nil
+		}
+		return json.Marshal(v.Number)
+	case Duration, Filesize:
+		return json.Marshal(int64(v.Number))
+	case Bool:
+		return []byte(v.Raw), nil
+	case List:
+		list := v.List
+		if list == nil {
+			list = []Value{}
+		}
+		return json.Marshal(list)
+	case Record:
+		var b strings.Builder
+		b.WriteByte('{')
+		for i, f := range v.Record {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			key, _ := json.Marshal(f.Key)
+			value, err := f.Value.MarshalJSON()
+			if err != nil {
+				return nil, err
+			}
+			b.Write(key)
+			b.WriteByte(':')
+			b.Write(value)
+		}
+		b.WriteByte('}')
+		return []byte(b.String()), nil
+	default:
+		return []byte("null"), nil
+	}
+}
+
+func formatString(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(b, `\u{%x}`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+}
+
+// A key can be written bare if it can't be mistaken for anything else.
+func isBareKey(key string) bool {
+	if key == "" || !utf8.ValidString(key) {
+		return false
+	}
+	for _, r := range key {
+		if !(r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return classify(key).Kind == String
+}
@@ -0,0 +1,200 @@
+package nuon
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		expected Value
+	}{
+		"Bare word string": {
+			input:    "hello",
+			expected: NewString("hello"),
+		},
+		"Double-quoted string with escapes": {
+			input:    `"a\nb \"c\" \u{e9}"`,
+			expected: NewString("a\nb \"c\" é"),
+		},
+		"Escaped surrogate pair": {
+			input:    `"\ud83d\ude00 \u00e9"`,
+			expected: NewString("😀 é"),
+		},
+		"Escaped lone surrogate": {
+			input:    `"\ud83d!"`,
+			expected: NewString("\uFFFD!"),
+		},
+		"Single-quoted string is raw": {
+			input:    `'a\nb'`,
+			expected: NewString(`a\nb`),
+		},
+		"Int": {
+			input:    "-42",
+			expected: Value{Kind: Int, Raw: "-42", Number: -42},
+		},
+		"Int with leading zeros is decimal": {
+			input:    "010",
+			expected: Value{Kind: Int, Raw: "010", Number: 10},
+		},
+		"Hex int": {
+			input:    "-0xff",
+			expected: Value{Kind: Int, Raw: "-0xff", Number: -255},
+		},
+		"Binary int": {
+			input:    "0b101",
+			expected: Value{Kind: Int, Raw: "0b101", Number: 5},
+		},
+		"Float": {
+			input:    "1.5",
+			expected: Value{Kind: Float, Raw: "1.5", Number: 1.5},
+		},
+		"Duration": {
+			input:    "3day",
+			expected: Value{Kind: Duration, Raw: "3day", Number: 3 * 86400e9},
+		},
+		"File size": {
+			input:    "1.5kB",
+			expected: Value{Kind: Filesize, Raw: "1.5kB", Number: 1500},
+		},
+		"Binary file size": {
+			input:    "2KiB",
+			expected: Value{Kind: Filesize, Raw: "2KiB", Number: 2048},
+		},
+		"Date": {
+			input:    "2024-07-01T10:00:00.123-05:00",
+			expected: Value{Kind: Date, Raw: "2024-07-01T10:00:00.123-05:00"},
+		},
+		"Bool and null": {
+			input: "[true false null]",
+			expected: Value{Kind: List, List: []Value{
+				{Kind: Bool, Raw: "true"},
+				{Kind: Bool, Raw: "false"},
+				{Kind: Null, Raw: "null"},
+			}},
+		},
+		"List with optional commas and comments": {
+			input: "[a, b # a comment\n c]",
+			expected: Value{Kind: List, List: []Value{
+				NewString("a"),
+				NewString("b"),
+				NewString("c"),
+			}},
+		},
+		"Record": {
+			input: `{name: "README.md", "the size": 1kb}`,
+			expected: Value{Kind: Record, Record: []Field{
+				{Key: "name", Value: NewString("README.md")},
+				{Key: "the size", Value: Value{Kind: Filesize, Raw: "1kb", Number: 1000}},
+			}},
+		},
+		"Table": {
+			input: "[[name, size]; [a, 1kb], [b, 2kb]]",
+			expected: Value{Kind: List, List: []Value{
+				{Kind: Record, Record: []Field{
+					{Key: "name", Value: NewString("a")},
+					{Key: "size", Value: Value{Kind: Filesize, Raw: "1kb", Number: 1000}},
+				}},
+				{Kind: Record, Record: []Field{
+					{Key: "name", Value: NewString("b")},
+					{Key: "size", Value: Value{Kind: Filesize, Raw: "2kb", Number: 2000}},
+				}},
+			}},
+		},
+		"Empty table": {
+			input:    "[[name, size];]",
+			expected: Value{Kind: List, List: []Value{}},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			v, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(v, tt.expected) {
+				t.Errorf("Parse() = %+v, want %+v", v, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]struct {
+		input          string
+		expectedOffset int
+	}{
+		"Unterminated list":   {input: "[a, b", expectedOffset: 5},
+		"Unterminated string": {input: `[a, "b]`, expectedOffset: 4},
+		"Missing colon":       {input: "{a 1}", expectedOffset: 3},
+		"Ragged table":        {input: "[[a, b]; [1]]", expectedOffset: 9},
+		"Trailing content":    {input: "[a] ]", expectedOffset: 4},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse(tt.input)
+			syntaxErr, ok := err.(*SyntaxError)
+			if !ok {
+				t.Fatalf("Parse() error = %v, want a *SyntaxError", err)
+			}
+			if syntaxErr.Offset != tt.expectedOffset {
+				t.Errorf("Parse() error offset = %d, want %d", syntaxErr.Offset, tt.expectedOffset)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []string{
+		`[]`,
+		`{}`,
+		`"hello"`,
+		`[1, 2.5, -3, 1.5kB, 3day, 500ms, 2024-07-01T10:00:00-05:00, true, null]`,
+		`{name: "README.md", type: "file", size: 1.2KiB, "with space": "a\nb"}`,
+		`[{a: [1, {b: "c"}]}]`,
+	}
+
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			v, err := Parse(input)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if out := Format(v); out != input {
+				t.Errorf("Format() = %s, want %s", out, input)
+			}
+		})
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`"hello"`, `"hello"`},
+		{`[1, 2.5e3, -3, true, null]`, `[1,2.5e3,-3,true,null]`},
+		{`[1_000, 1.5kB, 1sec, 2024-07-01]`, `[1000,1500,1000000000,"2024-07-01"]`},
+		{`{name: "README.md", "with space": [], nested: {a: b}}`, `{"name":"README.md","with space":[],"nested":{"a":"b"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			v, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			out, err := json.Marshal(v)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("json.Marshal() = %s, want %s", out, tt.want)
+			}
+		})
+	}
+}